@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ysh86/ft64/d2xx"
+)
+
+// channels checks the EEPROM channel settings of all the boards, and repairs
+// them with "fix".
+func channels(args []string) {
+	fix := len(args) > 0 && args[0] == "fix"
+	boards, err := d2xx.FindROMs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	for _, b := range boards {
+		name, _ := boardName(&b)
+		dev, err := d2xx.Open(b.Index, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", name, err)
+			continue
+		}
+		var issues []d2xx.ChannelIssue
+		if fix {
+			issues, err = dev.RepairChannels()
+		} else {
+			issues, err = dev.ChannelIssues()
+		}
+		dev.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", name, err)
+			continue
+		}
+		switch {
+		case len(issues) == 0:
+			fmt.Printf("%s: ok\n", name)
+		case fix:
+			for _, i := range issues {
+				fmt.Printf("%s: repaired %s\n", name, i)
+			}
+			fmt.Printf("%s: replug the board to use the new settings\n", name)
+		default:
+			for _, i := range issues {
+				fmt.Printf("%s: %s\n", name, i)
+			}
+		}
+	}
+}
+
+// warnChannels prints the EEPROM channel settings of a board to repair.
+func warnChannels(name string, rom board) {
+	for _, i := range rom.ChannelIssues() {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s; run \"cmd channels fix\"\n", name, i)
+	}
+}
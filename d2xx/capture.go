@@ -0,0 +1,144 @@
+package d2xx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Capture is a record of the 8 xDBUS pins sampled at a fixed rate.
+type Capture struct {
+	// Rate is the sample rate in Hz, rounded.
+	Rate int64
+	// Samples has one byte per sample; D0 in bit 0 up to D7 in bit 7.
+	Samples []byte
+}
+
+// Capture samples the 8 xDBUS pins n times in synchronous bit-bang mode.
+//
+// All the pins are inputs. In synchronous bit-bang mode the pins are sampled
+// each time a byte is clocked out, at 16 times the baud rate. The chip can
+// only approach the baud rate asked for, so Capture.Rate is the rate it
+// actually uses. The samples are contiguous as long as the host keeps up with
+// the USB transfers.
+//
+// The device is left in synchronous bit-bang mode.
+func (d *Device) Capture(rate int64, n int) (*Capture, error) {
+	if rate < 16 || n <= 0 {
+		return nil, errors.New("d2xx: invalid capture parameters")
+	}
+	if err := d.setBitMode(0, BitModeSyncBitbang); err != nil {
+		return nil, err
+	}
+	if err := d.setBaudRate(rate / 16); err != nil {
+		return nil, err
+	}
+	if err := d.purge(); err != nil {
+		return nil, err
+	}
+	c := &Capture{Rate: d.actualBaudRate(rate/16) * 16, Samples: make([]byte, n)}
+
+	// Keep one chunk ahead in the device so it doesn't stall while the
+	// previous samples are read.
+	const chunk = 4096
+	var zeros [chunk]byte
+	size := func(offset int) int {
+		if l := n - offset; l < chunk {
+			return l
+		}
+		return chunk
+	}
+	if err := d.writeAll(zeros[:size(0)]); err != nil {
+		return nil, err
+	}
+	for offset := 0; offset < n; {
+		l := size(offset)
+		if next := offset + l; next < n {
+			if err := d.writeAll(zeros[:size(next)]); err != nil {
+				return nil, err
+			}
+		}
+		if err := d.readAll(c.Samples[offset : offset+l]); err != nil {
+			return nil, err
+		}
+		offset += l
+	}
+	return c, nil
+}
+
+// pinName returns the name of the i-th pin.
+func pinName(names []string, i int) string {
+	if i < len(names) && names[i] != "" {
+		return names[i]
+	}
+	return Pin(i).String()
+}
+
+// time returns the time of the i-th sample in ns.
+func (c *Capture) time(i int) int64 {
+	return int64(i) * 1000000000 / c.Rate
+}
+
+// WriteVCD writes the capture as a Value Change Dump, with 1ns resolution.
+//
+// names are the signal names of D0~D7. Missing or empty names default to the
+// pin name.
+func (c *Capture) WriteVCD(w io.Writer, names []string) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "$version ft64 $end\n")
+	fmt.Fprintf(b, "$timescale 1 ns $end\n")
+	fmt.Fprintf(b, "$scope module xdbus $end\n")
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(b, "$var wire 1 %c %s $end\n", '!'+i, pinName(names, i))
+	}
+	fmt.Fprintf(b, "$upscope $end\n")
+	fmt.Fprintf(b, "$enddefinitions $end\n")
+	prev := 0
+	for i, s := range c.Samples {
+		changed := int(s) ^ prev
+		if i == 0 {
+			changed = 0xff
+			fmt.Fprintf(b, "#0\n$dumpvars\n")
+		} else if changed == 0 {
+			continue
+		} else {
+			fmt.Fprintf(b, "#%d\n", c.time(i))
+		}
+		for k := 0; k < 8; k++ {
+			if changed&(1<<k) != 0 {
+				fmt.Fprintf(b, "%d%c\n", s>>k&1, '!'+k)
+			}
+		}
+		if i == 0 {
+			fmt.Fprintf(b, "$end\n")
+		}
+		prev = int(s)
+	}
+	if len(c.Samples) != 0 {
+		fmt.Fprintf(b, "#%d\n", c.time(len(c.Samples)))
+	}
+	return b.Flush()
+}
+
+// WriteCSV writes the capture as CSV, one line per sample with the time in ns
+// followed by the level of D0~D7.
+//
+// names are the column names of D0~D7. Missing or empty names default to the
+// pin name.
+func (c *Capture) WriteCSV(w io.Writer, names []string) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "time_ns")
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(b, ",%s", pinName(names, i))
+	}
+	fmt.Fprintf(b, "\n")
+	for i, s := range c.Samples {
+		fmt.Fprintf(b, "%d", c.time(i))
+		for k := 0; k < 8; k++ {
+			fmt.Fprintf(b, ",%d", s>>k&1)
+		}
+		fmt.Fprintf(b, "\n")
+	}
+	return b.Flush()
+}
@@ -0,0 +1,128 @@
+package d2xx
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// CBus controls the 4 CBus bit-bang pins of a FT232H or FT232R.
+//
+// The 4 bits are C5, C6, C8 and C9 on a FT232H, C0~C3 on a FT232R. A pin is
+// only usable once its EEPROM mux is set to I/O mode.
+//
+// The mode is set with a single mask, the direction in the high nibble and the
+// output level in the low nibble, so every change rewrites all 4 pins.
+//
+// It is not safe for concurrent use.
+type CBus struct {
+	*Device
+	// usable is the mask of the pins in I/O mode.
+	usable byte
+	dir    byte
+	value  byte
+}
+
+// OpenCBus opens the i-th device with its usable CBus pins as inputs. opts can
+// be nil.
+func OpenCBus(i int, opts *Options) (*CBus, error) {
+	d, err := Open(i, opts)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCBus(d)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewCBus switches an opened device to CBus bit-bang mode.
+func NewCBus(d *Device) (*CBus, error) {
+	var ee ftdi.EEPROM
+	if err := d.readEEPROM(&ee); err != nil {
+		return nil, err
+	}
+	c := &CBus{Device: d}
+	switch d.t {
+	case ftdi.FT232H:
+		h := ee.AsFT232H()
+		for i, m := range []ftdi.FT232hCBusMux{h.Cbus5, h.Cbus6, h.Cbus8, h.Cbus9} {
+			if m == ftdi.FT232hCBusIOMode {
+				c.usable |= 1 << uint(i)
+			}
+		}
+	case ftdi.FT232R:
+		r := ee.AsFT232R()
+		for i, m := range []ftdi.FT232rCBusMux{r.Cbus0, r.Cbus1, r.Cbus2, r.Cbus3} {
+			if m == ftdi.FT232rCBusIOMode {
+				c.usable |= 1 << uint(i)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("d2xx: %s doesn't support CBus bit-bang", d.t)
+	}
+	if c.usable == 0 {
+		return nil, errors.New("d2xx: no CBus pin is in I/O mode; program the EEPROM")
+	}
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Usable returns the mask of the pins in I/O mode.
+func (c *CBus) Usable() byte {
+	return c.usable
+}
+
+// SetDirection sets the direction of the pins in mask.
+func (c *CBus) SetDirection(mask byte, out bool) error {
+	if err := c.check(mask); err != nil {
+		return err
+	}
+	if out {
+		c.dir |= mask
+	} else {
+		c.dir &^= mask
+	}
+	return c.flush()
+}
+
+// Set sets the output level of the pins in mask.
+//
+// The level is kept while a pin is an input and applied once it becomes an
+// output.
+func (c *CBus) Set(mask byte, level bool) error {
+	if err := c.check(mask); err != nil {
+		return err
+	}
+	if level {
+		c.value |= mask
+	} else {
+		c.value &^= mask
+	}
+	return c.flush()
+}
+
+// Get returns the levels of the 4 pins, read back from the device.
+func (c *CBus) Get() (byte, error) {
+	l, err := c.getBitMode()
+	if err != nil {
+		return 0, err
+	}
+	return l & 0x0f, nil
+}
+
+func (c *CBus) check(mask byte) error {
+	if mask&^c.usable != 0 {
+		return fmt.Errorf("d2xx: CBus pins 0x%x are not in I/O mode", mask&^c.usable)
+	}
+	return nil
+}
+
+func (c *CBus) flush() error {
+	return c.setBitMode(c.dir<<4|c.value, BitModeCbusBitbang)
+}
@@ -0,0 +1,118 @@
+package d2xx
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// ChannelIssue is a setting of a FT2232H EEPROM which keeps a channel from
+// being used in MPSSE mode through the D2XX driver.
+type ChannelIssue struct {
+	// Channel is the channel of the chip, "A" or "B".
+	Channel string
+	// Field is the EEPROMFT2232H field, e.g. "ADriverType".
+	Field string
+	Value uint8
+}
+
+func (c ChannelIssue) String() string {
+	if strings.HasSuffix(c.Field, "DriverType") {
+		return fmt.Sprintf("channel %s: %s is %d (VCP), must be 0 (D2XX)", c.Channel, c.Field, c.Value)
+	}
+	return fmt.Sprintf("channel %s: %s is %d, must be 0 for MPSSE", c.Channel, c.Field, c.Value)
+}
+
+// channelField is a setting checked by checkChannels.
+type channelField struct {
+	channel string
+	name    string
+	v       *uint8
+}
+
+// channelFields returns the settings of both channels which must be zero:
+// D2XX driver and neither 245 FIFO nor fast serial.
+func channelFields(ee *ftdi.EEPROMFT2232H) []channelField {
+	return []channelField{
+		{"A", "ADriverType", &ee.ADriverType},
+		{"A", "AIsFifo", &ee.AIsFifo},
+		{"A", "AIsFifoTar", &ee.AIsFifoTar},
+		{"A", "AIsFastSer", &ee.AIsFastSer},
+		{"B", "BDriverType", &ee.BDriverType},
+		{"B", "BIsFifo", &ee.BIsFifo},
+		{"B", "BIsFifoTar", &ee.BIsFifoTar},
+		{"B", "BIsFastSer", &ee.BIsFastSer},
+	}
+}
+
+// checkChannels returns the settings of ee to repair.
+func checkChannels(ee *ftdi.EEPROMFT2232H) []ChannelIssue {
+	var out []ChannelIssue
+	for _, f := range channelFields(ee) {
+		if *f.v != 0 {
+			out = append(out, ChannelIssue{Channel: f.channel, Field: f.name, Value: *f.v})
+		}
+	}
+	return out
+}
+
+// ft2232hEEPROM reads the EEPROM of a FT2232H into ee and returns its
+// FT2232H view.
+func (d *device) ft2232hEEPROM(ee *ftdi.EEPROM) (*ftdi.EEPROMFT2232H, error) {
+	if d.t != ftdi.FT2232H {
+		return nil, fmt.Errorf("d2xx: %s isn't a FT2232H", d.t)
+	}
+	if err := d.readEEPROM(ee); err != nil {
+		return nil, err
+	}
+	e := ee.AsFT2232H()
+	if e == nil {
+		return nil, fmt.Errorf("d2xx: EEPROM is %d bytes, too short for a FT2232H", len(ee.Raw))
+	}
+	return e, nil
+}
+
+// ChannelIssues reads the EEPROM of a FT2232H and returns the settings
+// keeping its channels from being used in MPSSE mode through D2XX. The
+// EEPROM is shared by both channels.
+func (d *Device) ChannelIssues() ([]ChannelIssue, error) {
+	var ee ftdi.EEPROM
+	e, err := d.ft2232hEEPROM(&ee)
+	if err != nil {
+		return nil, err
+	}
+	return checkChannels(e), nil
+}
+
+// RepairChannels reprograms the EEPROM of a FT2232H so that both channels use
+// the D2XX driver and can enter MPSSE mode, keeping the other settings. It
+// returns the settings repaired, none if the EEPROM was fine.
+//
+// The new settings are only used once the device enumerates again.
+func (d *Device) RepairChannels() ([]ChannelIssue, error) {
+	var ee ftdi.EEPROM
+	e, err := d.ft2232hEEPROM(&ee)
+	if err != nil {
+		return nil, err
+	}
+	issues := checkChannels(e)
+	if len(issues) == 0 {
+		return nil, nil
+	}
+	for _, f := range channelFields(e) {
+		*f.v = 0
+	}
+	if err := d.programEEPROM(&ee); err != nil {
+		return nil, err
+	}
+	// verify
+	var got ftdi.EEPROM
+	if e, err = d.ft2232hEEPROM(&got); err != nil {
+		return nil, err
+	}
+	if left := checkChannels(e); len(left) != 0 {
+		return nil, fmt.Errorf("d2xx: EEPROM still has %s after programming", left[0])
+	}
+	return issues, nil
+}
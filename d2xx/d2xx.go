@@ -28,7 +28,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/ysh86/ft64/d2xx/ftdi"
@@ -51,15 +53,28 @@ func numDevices() (int, error) {
 
 //
 
-func openDev(opener func(i int) (d2xxHandle, int), i int) (*device, error) {
+// openDev opens the i-th device. The options are applied by setupCommon.
+func openDev(opener func(i int) (d2xxHandle, int), i int, opts *Options) (*device, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
 	h, e := opener(i)
-	d := &device{h: h}
+	d := &device{h: h, channel: strconv.Itoa(i), opts: *opts}
 	if e != 0 {
 		return d, toErr("Open", e)
 	}
 	if d.t, d.venID, d.devID, e = h.d2xxGetDeviceInfo(); e != 0 {
 		return d, toErr("GetDeviceInfo", e)
 	}
+	if d.serial, e = h.d2xxGetSerialNumber(); e != 0 {
+		return d, toErr("GetSerialNumber", e)
+	}
+	if d.locID, e = h.d2xxGetLocID(); e != 0 {
+		return d, toErr("GetLocID", e)
+	}
 	return d, nil
 }
 
@@ -69,19 +84,68 @@ func openDev(opener func(i int) (d2xxHandle, int), i int) (*device, error) {
 // device converts the int error type into Go native error and handles higher
 // level functionality like reading and writing to the USB connection.
 //
-// The content of the struct is immutable after initialization.
+// The content of the struct is immutable after initialization, except the
+// command queue and closed guarded by queueMu, and the handle replaced by
+// recoverMpsse.
+// Once commands are submitted, the MPSSE I/O must go through the queue.
 type device struct {
 	h     d2xxHandle
 	t     ftdi.DevType
 	venID uint16
 	devID uint16
-}
-
+	// serial is the USB serial number, used to reopen the device.
+	serial string
+	// locID is the USB location ID.
+	locID uint32
+	// channel names the device in errors.
+	channel string
+	opts    Options
+	// events is true when the driver signals incoming data, so reads can block
+	// instead of polling.
+	events bool
+
+	queueMu     sync.Mutex
+	queue       chan batch
+	queueDone   chan struct{}
+	queueClosed bool
+	// closed is set once the handle is closed.
+	closed bool
+}
+
+// closeDev closes the handle, once.
 func (d *device) closeDev() error {
-	// Not yet called.
+	d.stopQueue()
+	d.queueMu.Lock()
+	closed := d.closed
+	d.closed = true
+	d.queueMu.Unlock()
+	if closed {
+		return nil
+	}
 	return toErr("Close", d.h.d2xxClose())
 }
 
+// resetAndClose waits for the queued batches, sets the device back to its
+// default mode, then closes it. The batches are drained first so that none is
+// written while the mode is being reset.
+func (d *device) resetAndClose() error {
+	d.stopQueue()
+	if d.isClosed() {
+		return nil
+	}
+	err := d.setBitMode(0, BitModeReset)
+	if err2 := d.closeDev(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (d *device) isClosed() bool {
+	d.queueMu.Lock()
+	defer d.queueMu.Unlock()
+	return d.closed
+}
+
 // setupCommon is the general setup for common devices.
 //
 // It tries first the 'happy path' which doesn't reset the device. By doing so,
@@ -94,26 +158,29 @@ func (d *device) setupCommon() error {
 	// ignored.
 	// TODO(maruel): The FT232H doc claims a 512 byte packets support in hi-speed
 	// mode, which means that this would likely be better to use this value.
-	if e := d.h.d2xxSetUSBParameters(65536, 0); e != 0 {
+	if e := d.h.d2xxSetUSBParameters(d.opts.USBInTransferSize, d.opts.USBOutTransferSize); e != 0 {
 		return toErr("SetUSBParameters", e)
 	}
-	// Driver: Set I/O timeouts to 15 sec. The reason is that we want the
-	// timeouts to be very visible, at least as the driver is being developed.
-	if e := d.h.d2xxSetTimeouts(15000, 15000); e != 0 {
+	// Driver: Set I/O timeouts, 15 sec by default. The reason is that we want
+	// the timeouts to be very visible, at least as the driver is being
+	// developed.
+	if e := d.h.d2xxSetTimeouts(int(d.opts.ReadTimeout/time.Millisecond), int(d.opts.WriteTimeout/time.Millisecond)); e != 0 {
 		return toErr("SetTimeouts", e)
 	}
-	// Not sure: Disable event/error characters.
-	if e := d.h.d2xxSetChars(0, false, 0, false); e != 0 {
+	// Not sure: Disable event/error characters by default.
+	if e := d.h.d2xxSetChars(d.opts.EventChar, d.opts.EventCharEnabled, d.opts.ErrorChar, d.opts.ErrorCharEnabled); e != 0 {
 		return toErr("SetChars", e)
 	}
-	// Not sure: Latency timer at 1ms.
-	if e := d.h.d2xxSetLatencyTimer(1); e != 0 {
+	// Not sure: Latency timer at 1ms by default.
+	if e := d.h.d2xxSetLatencyTimer(uint8(d.opts.LatencyTimer / time.Millisecond)); e != 0 {
 		return toErr("SetLatencyTimer", e)
 	}
-	// Not sure: Turn on flow control to synchronize IN requests.
-	if e := d.h.d2xxSetFlowControl(); e != 0 {
+	// Not sure: Turn on flow control to synchronize IN requests by default.
+	if e := d.h.d2xxSetFlowControl(uint16(d.opts.FlowControl), d.opts.XOn, d.opts.XOff); e != 0 {
 		return toErr("SetFlowControl", e)
 	}
+	// Fall back to polling if the driver can't signal incoming data.
+	d.events = d.h.d2xxSetEventNotification() == 0
 	// Just in case. It's a very small cost.
 	return d.flushPending()
 }
@@ -123,7 +190,7 @@ func (d *device) reset() error {
 	if e := d.h.d2xxResetDevice(); e != 0 {
 		return toErr("Reset", e)
 	}
-	if err := d.setBitMode(0, bitModeReset); err != nil {
+	if err := d.setBitMode(0, BitModeReset); err != nil {
 		return err
 	}
 	// USB/driver: Flush any pending read buffer that had been sent by device
@@ -143,11 +210,16 @@ func (d *device) getBitMode() (byte, error) {
 
 // setBitMode change the mode of operation of the device.
 //
-// mask sets which pins are inputs and outputs for bitModeCbusBitbang.
-func (d *device) setBitMode(mask byte, mode bitMode) error {
+// mask sets which pins are inputs and outputs for BitModeCbusBitbang.
+func (d *device) setBitMode(mask byte, mode BitMode) error {
 	return toErr("SetBitMode", d.h.d2xxSetBitMode(mask, byte(mode)))
 }
 
+// purge discards the data in the driver's RX and TX buffers.
+func (d *device) purge() error {
+	return toErr("Purge", d.h.d2xxPurge(true, true))
+}
+
 // flushPending flushes any data left in the read buffer.
 func (d *device) flushPending() error {
 	var buf [128]byte
@@ -183,17 +255,44 @@ func (d *device) read(b []byte) (int, error) {
 	return n, toErr("Read", e)
 }
 
+// readWait is like read but blocks until some data is available or deadline
+// is reached.
+//
+// It requires events.
+func (d *device) readWait(b []byte, deadline time.Time) (int, error) {
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return d.read(b)
+	}
+	p, e := d.h.d2xxWaitEvent(uint32((timeout + time.Millisecond - 1) / time.Millisecond))
+	if p == 0 || e != 0 {
+		return 0, toErr("Read/WaitEvent", e)
+	}
+	v := int(p)
+	if v > len(b) {
+		v = len(b)
+	}
+	n, e := d.h.d2xxRead(b[:v])
+	return n, toErr("Read", e)
+}
+
 // readAll blocks to return all the data.
+//
+// It returns io.EOF when no data is received for 200ms.
 func (d *device) readAll(b []byte) error {
-	// TODO(maruel): Use FT_SetEventNotification() instead of looping when
-	// waiting for bytes.
 	last := time.Now()
 	for offset := 0; offset != len(b); {
 		chunk := len(b) - offset
 		if chunk > 4096 {
 			chunk = 4096
 		}
-		p, err := d.read(b[offset : offset+chunk])
+		var p int
+		var err error
+		if d.events {
+			p, err = d.readWait(b[offset:offset+chunk], last.Add(200*time.Millisecond))
+		} else {
+			p, err = d.read(b[offset : offset+chunk])
+		}
 		if err != nil {
 			return err
 		}
@@ -216,20 +315,90 @@ func (d *device) write(b []byte) (int, error) {
 	return n, toErr("Write", e)
 }
 
+// txQueueLimit is how many bytes may wait in the driver TX queue before more
+// are written.
+const txQueueLimit = 8192
+
+// WriteError is returned when a batch can't be written entirely.
+type WriteError struct {
+	Channel string
+	// Written bytes out of Total were accepted by the driver.
+	Written int
+	Total   int
+	// RX and TX are the driver queue lengths when it failed.
+	RX  uint32
+	TX  uint32
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("d2xx: channel %s: wrote %d of %d bytes (RX queue %d, TX queue %d): %v", e.Channel, e.Written, e.Total, e.RX, e.TX, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// status returns the RX and TX queue lengths.
+func (d *device) status() (uint32, uint32, error) {
+	rx, tx, _, e := d.h.d2xxGetStatus()
+	return rx, tx, toErr("GetStatus", e)
+}
+
+// writeStallLimit is how many times in a row writeAll waits without the
+// driver accepting a byte nor draining its TX queue, about 5s, before it
+// gives up. It bounds writeAll when WriteTimeout is 0.
+const writeStallLimit = 5000
+
 // writeAll blocks until all data is written.
+//
+// It waits for the driver TX queue to drain below txQueueLimit before writing
+// each chunk. It fails with a *WriteError, wrapping os.ErrDeadlineExceeded if
+// the batch isn't written within WriteTimeout, or io.ErrNoProgress if the
+// driver stalls.
 func (d *device) writeAll(b []byte) error {
+	var deadline time.Time
+	if d.opts.WriteTimeout != 0 {
+		deadline = time.Now().Add(d.opts.WriteTimeout)
+	}
+	fail := func(offset int, err error) error {
+		rx, tx, _ := d.status()
+		return &WriteError{Channel: d.channel, Written: offset, Total: len(b), RX: rx, TX: tx, Err: err}
+	}
+	stalls := 0
+	lastTX := uint32(0)
 	for offset := 0; offset != len(b); {
 		chunk := len(b) - offset
 		if chunk > 4096 {
 			chunk = 4096
 		}
-		p, err := d.write(b[offset : offset+chunk])
+		_, tx, err := d.status()
 		if err != nil {
-			return err
+			return fail(offset, err)
 		}
-		if p != 0 {
+		p := 0
+		if tx+uint32(chunk) <= txQueueLimit {
+			p, err = d.write(b[offset : offset+chunk])
 			offset += p
+			if err != nil {
+				return fail(offset, err)
+			}
+			if p == chunk {
+				stalls = 0
+				continue
+			}
 		}
+		// The device isn't keeping up; let the queue drain.
+		if p != 0 || tx < lastTX {
+			stalls = 0
+		} else if stalls++; stalls >= writeStallLimit {
+			return fail(offset, io.ErrNoProgress)
+		}
+		lastTX = tx
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return fail(offset, os.ErrDeadlineExceeded)
+		}
+		time.Sleep(time.Millisecond)
 	}
 	return nil
 }
@@ -343,35 +512,71 @@ func (d *device) setBaudRate(hz int64) error {
 	return toErr("SetBaudRate", d.h.d2xxSetBaudRate(uint32(hz)))
 }
 
+// actualBaudRate returns the baud rate the chip uses when asked for hz.
+//
+// The chip divides a base clock, 3MHz or 12MHz for the hi-speed chips above
+// 1200 bauds, by an integer plus a multiple of 1/8. The divisors 1 and 1.5 are
+// special cases, and there is nothing between 1.5 and 2.
+func (d *device) actualBaudRate(hz int64) int64 {
+	base := int64(3000000)
+	switch d.t {
+	case ftdi.FT2232H, ftdi.FT4232H, ftdi.FT232H:
+		if hz*10 > 120000000/0x3fff {
+			base = 12000000
+		}
+	}
+	switch {
+	case hz >= base:
+		return base
+	case hz >= base*2/3:
+		return base * 2 / 3
+	case hz >= base/2:
+		return base / 2
+	}
+	// the divisor in 1/8 units, rounded to the nearest
+	div := (base*16/hz + 1) / 2
+	if div > 0x1ffff {
+		div = 0x1ffff
+	}
+	return (base*16/div + 1) / 2
+}
+
 //
 
 const missing = -1
 const noCGO = -2
 
-// bitMode is used by setBitMode to change the chip behavior.
-type bitMode uint8
+// Flags of d2xxOpenEx.
+const (
+	openBySerial      = 1
+	openByDescription = 2
+	openByLocation    = 4
+)
+
+// BitMode is used by Device.SetBitMode to change the chip behavior.
+type BitMode uint8
 
 const (
 	// Resets all Pins to their default value
-	bitModeReset bitMode = 0x00
+	BitModeReset BitMode = 0x00
 	// Sets the DBus to asynchronous bit-bang.
-	bitModeAsyncBitbang bitMode = 0x01
+	BitModeAsyncBitbang BitMode = 0x01
 	// Switch to MPSSE mode (FT2232, FT2232H, FT4232H and FT232H).
-	bitModeMpsse bitMode = 0x02
+	BitModeMpsse BitMode = 0x02
 	// Sets the DBus to synchronous bit-bang (FT232R, FT245R, FT2232, FT2232H,
 	// FT4232H and FT232H).
-	bitModeSyncBitbang bitMode = 0x04
+	BitModeSyncBitbang BitMode = 0x04
 	// Switch to MCU host bus emulation (FT2232, FT2232H, FT4232H and FT232H).
-	bitModeMcuHost bitMode = 0x08
+	BitModeMcuHost BitMode = 0x08
 	// Switch to fast opto-isolated serial mode (FT2232, FT2232H, FT4232H and
 	// FT232H).
-	bitModeFastSerial bitMode = 0x10
+	BitModeFastSerial BitMode = 0x10
 	// Sets the CBus in 4 bits bit-bang mode (FT232R and FT232H)
 	// In this case, upper nibble controls which pin is output/input, lower
 	// controls which of outputs are high and low.
-	bitModeCbusBitbang bitMode = 0x20
+	BitModeCbusBitbang BitMode = 0x20
 	// Single Channel Synchronous 245 FIFO mode (FT2232H and FT232H).
-	bitModeSyncFifo bitMode = 0x40
+	BitModeSyncFifo BitMode = 0x40
 )
 
 func toErr(s string, e int) error {
@@ -429,12 +634,21 @@ func toErr(s string, e int) error {
 }
 
 // Common functions that must be implemented in addition to
-// d2xxGetLibraryVersion(), d2xxCreateDeviceInfoList() and d2xxOpen().
+// d2xxGetLibraryVersion(), d2xxCreateDeviceInfoList(), d2xxOpen() and
+// d2xxOpenEx().
 type d2xxHandle interface {
 	d2xxClose() int
 	// d2xxResetDevice takes >1.2ms
 	d2xxResetDevice() int
+	// d2xxResetPort resets the USB port the device is attached to.
+	d2xxResetPort() int
+	// d2xxCyclePort makes the device re-enumerate. The handle must be closed
+	// afterward.
+	d2xxCyclePort() int
 	d2xxGetDeviceInfo() (ftdi.DevType, uint16, uint16, int)
+	d2xxGetSerialNumber() (string, int)
+	// d2xxGetLocID returns the USB location ID of the device.
+	d2xxGetLocID() (uint32, int)
 	d2xxEEPROMRead(d ftdi.DevType, e *ftdi.EEPROM) int
 	d2xxEEPROMProgram(e *ftdi.EEPROM) int
 	d2xxEraseEE() int
@@ -444,17 +658,31 @@ type d2xxHandle interface {
 	d2xxEEUAWrite(ua []byte) int
 	d2xxSetChars(eventChar byte, eventEn bool, errorChar byte, errorEn bool) int
 	d2xxSetUSBParameters(in, out int) int
-	d2xxSetFlowControl() int
+	d2xxSetFlowControl(mode uint16, xon, xoff byte) int
 	d2xxSetTimeouts(readMS, writeMS int) int
 	d2xxSetLatencyTimer(delayMS uint8) int
 	d2xxSetBaudRate(hz uint32) int
+	d2xxSetDataCharacteristics(bits, stop, parity byte) int
+	d2xxSetBreak(on bool) int
+	d2xxSetDtr(on bool) int
+	d2xxSetRts(on bool) int
+	d2xxGetModemStatus() (uint32, int)
 	// d2xxGetQueueStatus takes >60µs
 	d2xxGetQueueStatus() (uint32, int)
+	// d2xxGetStatus returns the RX and TX queue lengths and the event status.
+	d2xxGetStatus() (uint32, uint32, uint32, int)
+	// d2xxSetEventNotification arms the event signaled when RX data or a modem
+	// status change arrives. It must be called before d2xxWaitEvent.
+	d2xxSetEventNotification() int
+	// d2xxWaitEvent blocks until the event is signaled or timeoutMS expires,
+	// then returns the RX queue length like d2xxGetQueueStatus.
+	d2xxWaitEvent(timeoutMS uint32) (uint32, int)
 	// d2xxRead takes <5µs if d2xxGetQueueStatus was called just before,
 	// 300µs~800µs otherwise (!)
 	d2xxRead(b []byte) (int, int)
 	// d2xxWrite takes >0.1ms
 	d2xxWrite(b []byte) (int, int)
+	d2xxPurge(rx, tx bool) int
 	d2xxGetBitMode() (byte, int)
 	// d2xxSetBitMode takes >0.1ms
 	d2xxSetBitMode(mask, mode byte) int
@@ -538,10 +766,30 @@ func (d d2xxLoggingHandle) d2xxResetDevice() int {
 	defer logDefer("d2xxResetDevice()")()
 	return d.d.d2xxResetDevice()
 }
+func (d d2xxLoggingHandle) d2xxResetPort() int {
+	defer logDefer("d2xxResetPort()")()
+	return d.d.d2xxResetPort()
+}
+func (d d2xxLoggingHandle) d2xxCyclePort() int {
+	defer logDefer("d2xxCyclePort()")()
+	return d.d.d2xxCyclePort()
+}
 func (d d2xxLoggingHandle) d2xxGetDeviceInfo() (ftdi.DevType, uint16, uint16, int) {
 	defer logDefer("d2xxGetDeviceInfo()")()
 	return d.d.d2xxGetDeviceInfo()
 }
+func (d d2xxLoggingHandle) d2xxGetSerialNumber() (string, int) {
+	f := logDefer("d2xxGetSerialNumber() = %q, %d")
+	s, e := d.d.d2xxGetSerialNumber()
+	f(s, e)
+	return s, e
+}
+func (d d2xxLoggingHandle) d2xxGetLocID() (uint32, int) {
+	f := logDefer("d2xxGetLocID() = %#x, %d")
+	l, e := d.d.d2xxGetLocID()
+	f(l, e)
+	return l, e
+}
 func (d d2xxLoggingHandle) d2xxEEPROMRead(dev ftdi.DevType, e *ftdi.EEPROM) int {
 	defer logDefer("d2xxEEPROMRead(%v, %d bytes)")(dev, e)
 	return d.d.d2xxEEPROMRead(dev, e)
@@ -578,9 +826,9 @@ func (d d2xxLoggingHandle) d2xxSetUSBParameters(in, out int) int {
 	defer logDefer("d2xxSetUSBParameters(%d, %d)")(in, out)
 	return d.d.d2xxSetUSBParameters(in, out)
 }
-func (d d2xxLoggingHandle) d2xxSetFlowControl() int {
-	defer logDefer("d2xxSetFlowControl()")()
-	return d.d.d2xxSetFlowControl()
+func (d d2xxLoggingHandle) d2xxSetFlowControl(mode uint16, xon, xoff byte) int {
+	defer logDefer("d2xxSetFlowControl(0x%04X, %d, %d)")(mode, xon, xoff)
+	return d.d.d2xxSetFlowControl(mode, xon, xoff)
 }
 func (d d2xxLoggingHandle) d2xxSetTimeouts(readMS, writeMS int) int {
 	defer logDefer("d2xxSetTimeouts(%d, %d)")(readMS, writeMS)
@@ -594,12 +842,50 @@ func (d d2xxLoggingHandle) d2xxSetBaudRate(hz uint32) int {
 	defer logDefer("d2xxSetBaudRate(%d)")(hz)
 	return d.d.d2xxSetBaudRate(hz)
 }
+func (d d2xxLoggingHandle) d2xxSetDataCharacteristics(bits, stop, parity byte) int {
+	defer logDefer("d2xxSetDataCharacteristics(%d, %d, %d)")(bits, stop, parity)
+	return d.d.d2xxSetDataCharacteristics(bits, stop, parity)
+}
+func (d d2xxLoggingHandle) d2xxSetBreak(on bool) int {
+	defer logDefer("d2xxSetBreak(%t)")(on)
+	return d.d.d2xxSetBreak(on)
+}
+func (d d2xxLoggingHandle) d2xxSetDtr(on bool) int {
+	defer logDefer("d2xxSetDtr(%t)")(on)
+	return d.d.d2xxSetDtr(on)
+}
+func (d d2xxLoggingHandle) d2xxSetRts(on bool) int {
+	defer logDefer("d2xxSetRts(%t)")(on)
+	return d.d.d2xxSetRts(on)
+}
+func (d d2xxLoggingHandle) d2xxGetModemStatus() (uint32, int) {
+	f := logDefer("d2xxGetModemStatus() = 0x%04X, %d")
+	s, e := d.d.d2xxGetModemStatus()
+	f(s, e)
+	return s, e
+}
 func (d d2xxLoggingHandle) d2xxGetQueueStatus() (uint32, int) {
 	f := logDefer("d2xxGetQueueStatus() = %d, %d")
 	p, e := d.d.d2xxGetQueueStatus()
 	f(p, e)
 	return p, e
 }
+func (d d2xxLoggingHandle) d2xxGetStatus() (uint32, uint32, uint32, int) {
+	f := logDefer("d2xxGetStatus() = %d, %d, 0x%X, %d")
+	rx, tx, ev, e := d.d.d2xxGetStatus()
+	f(rx, tx, ev, e)
+	return rx, tx, ev, e
+}
+func (d d2xxLoggingHandle) d2xxSetEventNotification() int {
+	defer logDefer("d2xxSetEventNotification()")()
+	return d.d.d2xxSetEventNotification()
+}
+func (d d2xxLoggingHandle) d2xxWaitEvent(timeoutMS uint32) (uint32, int) {
+	f := logDefer("d2xxWaitEvent(%d) = %d, %d")
+	p, e := d.d.d2xxWaitEvent(timeoutMS)
+	f(timeoutMS, p, e)
+	return p, e
+}
 func (d d2xxLoggingHandle) d2xxRead(b []byte) (int, int) {
 	f := logDefer("d2xxRead(%d bytes) = %#x")
 	n, e := d.d.d2xxRead(b)
@@ -610,6 +896,10 @@ func (d d2xxLoggingHandle) d2xxWrite(b []byte) (int, int) {
 	defer logDefer("d2xxWrite(%#x)")(b)
 	return d.d.d2xxWrite(b)
 }
+func (d d2xxLoggingHandle) d2xxPurge(rx, tx bool) int {
+	defer logDefer("d2xxPurge(%t, %t)")(rx, tx)
+	return d.d.d2xxPurge(rx, tx)
+}
 func (d d2xxLoggingHandle) d2xxGetBitMode() (byte, int) {
 	f := logDefer("d2xxGetBitMode() = %02X")
 	b, e := d.d.d2xxGetBitMode()
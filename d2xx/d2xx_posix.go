@@ -6,10 +6,158 @@ package d2xx
 
 /*
 #include "ftd2xx.h"
+#include <stdint.h>
 #include <stdlib.h>
+
+// FT_OpenEx takes the location ID in place of the pointer.
+static FT_STATUS ft64OpenByLocation(DWORD loc, FT_HANDLE *h) {
+	return FT_OpenEx((PVOID)(uintptr_t)loc, FT_OPEN_BY_LOCATION, h);
+}
+
+// ft64GetLocId returns the location ID of an opened device. Windows lacks
+// FT_GetDeviceLocId, so the handle is looked for in the device list.
+static FT_STATUS ft64GetLocId(FT_HANDLE h, DWORD *loc) {
+#ifdef _WIN32
+	DWORD n, i, flags, t, id;
+	char serial[16], desc[64];
+	FT_HANDLE o;
+	FT_STATUS e = FT_CreateDeviceInfoList(&n);
+	for (i = 0; e == FT_OK && i < n; i++) {
+		e = FT_GetDeviceInfoDetail(i, &flags, &t, &id, loc, serial, desc, &o);
+		if (e == FT_OK && o == h) {
+			return FT_OK;
+		}
+	}
+	return e == FT_OK ? FT_DEVICE_NOT_FOUND : e;
+#else
+	return FT_GetDeviceLocId(h, loc);
+#endif
+}
+
+// The driver signals an OS specific event object. ft64WaitEvent checks the RX
+// queue before waiting so that no event is lost in between.
+#ifdef _WIN32
+// rx is signaled by the driver. closed is a manual-reset event which is never
+// reset, so that it wakes up every waiter, even the ones not waiting yet.
+struct ft64Event {
+	HANDLE rx;
+	HANDLE closed;
+};
+
+typedef struct ft64Event *ft64Event;
+
+static ft64Event ft64NewEvent(void) {
+	ft64Event ev = calloc(1, sizeof(*ev));
+	if (ev == NULL) {
+		return NULL;
+	}
+	ev->rx = CreateEvent(NULL, FALSE, FALSE, NULL);
+	ev->closed = CreateEvent(NULL, TRUE, FALSE, NULL);
+	if (ev->rx == NULL || ev->closed == NULL) {
+		if (ev->rx != NULL) {
+			CloseHandle(ev->rx);
+		}
+		if (ev->closed != NULL) {
+			CloseHandle(ev->closed);
+		}
+		free(ev);
+		return NULL;
+	}
+	return ev;
+}
+
+static void ft64FreeEvent(ft64Event ev) {
+	CloseHandle(ev->rx);
+	CloseHandle(ev->closed);
+	free(ev);
+}
+
+// ft64EventArg is the argument given to FT_SetEventNotification.
+static PVOID ft64EventArg(ft64Event ev) {
+	return ev->rx;
+}
+
+// ft64CloseEvent wakes up the waiters, if any, and makes the next waits return
+// immediately.
+static void ft64CloseEvent(ft64Event ev) {
+	SetEvent(ev->closed);
+}
+
+static FT_STATUS ft64WaitEvent(FT_HANDLE h, ft64Event ev, DWORD ms, DWORD *rx) {
+	HANDLE hs[2] = {ev->rx, ev->closed};
+	FT_STATUS e = FT_GetQueueStatus(h, rx);
+	if (e == FT_OK && *rx == 0) {
+		WaitForMultipleObjects(2, hs, FALSE, ms);
+		e = FT_GetQueueStatus(h, rx);
+	}
+	return e;
+}
+#else
+#include <sys/time.h>
+
+// The driver is given a pointer to h, the first member.
+struct ft64Event {
+	EVENT_HANDLE h;
+	int closed;
+};
+
+typedef struct ft64Event *ft64Event;
+
+static ft64Event ft64NewEvent(void) {
+	ft64Event ev = calloc(1, sizeof(*ev));
+	if (ev != NULL) {
+		pthread_mutex_init(&ev->h.eMutex, NULL);
+		pthread_cond_init(&ev->h.eCondVar, NULL);
+	}
+	return ev;
+}
+
+static void ft64FreeEvent(ft64Event ev) {
+	pthread_cond_destroy(&ev->h.eCondVar);
+	pthread_mutex_destroy(&ev->h.eMutex);
+	free(ev);
+}
+
+// ft64EventArg is the argument given to FT_SetEventNotification.
+static PVOID ft64EventArg(ft64Event ev) {
+	return &ev->h;
+}
+
+// ft64CloseEvent wakes up the waiters, if any, and makes the next waits return
+// immediately.
+static void ft64CloseEvent(ft64Event ev) {
+	pthread_mutex_lock(&ev->h.eMutex);
+	ev->closed = 1;
+	pthread_cond_broadcast(&ev->h.eCondVar);
+	pthread_mutex_unlock(&ev->h.eMutex);
+}
+
+static FT_STATUS ft64WaitEvent(FT_HANDLE h, ft64Event ev, DWORD ms, DWORD *rx) {
+	struct timeval now;
+	struct timespec ts;
+	FT_STATUS e;
+	gettimeofday(&now, NULL);
+	ts.tv_sec = now.tv_sec + ms / 1000;
+	ts.tv_nsec = now.tv_usec * 1000 + (ms % 1000) * 1000000;
+	if (ts.tv_nsec >= 1000000000) {
+		ts.tv_sec++;
+		ts.tv_nsec -= 1000000000;
+	}
+	pthread_mutex_lock(&ev->h.eMutex);
+	e = FT_GetQueueStatus(h, rx);
+	if (e == FT_OK && *rx == 0 && !ev->closed) {
+		pthread_cond_timedwait(&ev->h.eCondVar, &ev->h.eMutex, &ts);
+		e = FT_GetQueueStatus(h, rx);
+	}
+	pthread_mutex_unlock(&ev->h.eMutex);
+	return e;
+}
+#endif
 */
 import "C"
 import (
+	"strconv"
+	"sync"
 	"unsafe"
 
 	"github.com/ysh86/ft64/d2xx/ftdi"
@@ -31,6 +179,30 @@ func d2xxCreateDeviceInfoList() (int, int) {
 	return int(num), int(e)
 }
 
+// d2xxGetDeviceInfoDetail returns the i-th entry of the list created by
+// d2xxCreateDeviceInfoList.
+func d2xxGetDeviceInfoDetail(i int) (DeviceInfo, int) {
+	var flags, t, id, loc C.DWORD
+	var serial [16]C.char
+	var desc [64]C.char
+	var h C.FT_HANDLE
+	e := C.FT_GetDeviceInfoDetail(C.DWORD(i), &flags, &t, &id, &loc, C.LPVOID(unsafe.Pointer(&serial[0])), C.LPVOID(unsafe.Pointer(&desc[0])), &h)
+	if e != 0 {
+		return DeviceInfo{}, int(e)
+	}
+	return DeviceInfo{
+		Index:       i,
+		Type:        ftdi.DevType(t),
+		VenID:       uint16(id >> 16),
+		DevID:       uint16(id),
+		Serial:      C.GoString(&serial[0]),
+		Description: C.GoString(&desc[0]),
+		LocID:       uint32(loc),
+		Opened:      flags&C.FT_FLAGS_OPENED != 0,
+		HiSpeed:     flags&C.FT_FLAGS_HISPEED != 0,
+	}, 0
+}
+
 // Device functions.
 
 func d2xxOpen(i int) (d2xxHandle, int) {
@@ -42,14 +214,76 @@ func d2xxOpen(i int) (d2xxHandle, int) {
 	return handle(h), int(e)
 }
 
+// d2xxOpenEx opens a device by serial number or description. A location ID
+// is passed as a decimal string.
+func d2xxOpenEx(arg string, flags uint32) (d2xxHandle, int) {
+	var h C.FT_HANDLE
+	var e C.FT_STATUS
+	if flags == C.FT_OPEN_BY_LOCATION {
+		loc, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			return handle(0), 6 // FT_INVALID_PARAMETER
+		}
+		e = C.ft64OpenByLocation(C.DWORD(loc), &h)
+	} else {
+		carg := C.CString(arg)
+		defer C.free(unsafe.Pointer(carg))
+		e = C.FT_OpenEx(C.PVOID(unsafe.Pointer(carg)), C.DWORD(flags), &h)
+	}
+	if uintptr(h) == 0 && e == 0 {
+		panic("unexpected")
+	}
+	return handle(h), int(e)
+}
+
 func (h handle) d2xxClose() int {
-	return int(C.FT_Close(h.toH()))
+	// wake up and wait for the readers still waiting on the event, which is
+	// freed once the handle is closed
+	events.Lock()
+	ev, ok := events.m[h]
+	if ok {
+		delete(events.m, h)
+	}
+	events.Unlock()
+	if ok {
+		C.ft64CloseEvent(ev.ev)
+		ev.users.Wait()
+	}
+	e := int(C.FT_Close(h.toH()))
+	if ok {
+		C.ft64FreeEvent(ev.ev)
+	}
+	return e
 }
 
 func (h handle) d2xxResetDevice() int {
 	return int(C.FT_ResetDevice(h.toH()))
 }
 
+func (h handle) d2xxResetPort() int {
+	return int(C.FT_ResetPort(h.toH()))
+}
+
+func (h handle) d2xxCyclePort() int {
+	return int(C.FT_CyclePort(h.toH()))
+}
+
+func (h handle) d2xxGetSerialNumber() (string, int) {
+	var dev C.FT_DEVICE
+	var id C.DWORD
+	var serial [16]C.char
+	if e := C.FT_GetDeviceInfo(h.toH(), &dev, &id, &serial[0], nil, nil); e != 0 {
+		return "", int(e)
+	}
+	return C.GoString(&serial[0]), 0
+}
+
+func (h handle) d2xxGetLocID() (uint32, int) {
+	var loc C.DWORD
+	e := C.ft64GetLocId(h.toH(), &loc)
+	return uint32(loc), int(e)
+}
+
 func (h handle) d2xxGetDeviceInfo() (ftdi.DevType, uint16, uint16, int) {
 	var dev C.FT_DEVICE
 	var id C.DWORD
@@ -173,8 +407,8 @@ func (h handle) d2xxSetUSBParameters(in, out int) int {
 	return int(C.FT_SetUSBParameters(h.toH(), C.DWORD(in), C.DWORD(out)))
 }
 
-func (h handle) d2xxSetFlowControl() int {
-	return int(C.FT_SetFlowControl(h.toH(), C.FT_FLOW_RTS_CTS, 0, 0))
+func (h handle) d2xxSetFlowControl(mode uint16, xon, xoff byte) int {
+	return int(C.FT_SetFlowControl(h.toH(), C.USHORT(mode), C.UCHAR(xon), C.UCHAR(xoff)))
 }
 
 func (h handle) d2xxSetTimeouts(readMS, writeMS int) int {
@@ -189,12 +423,94 @@ func (h handle) d2xxSetBaudRate(hz uint32) int {
 	return int(C.FT_SetBaudRate(h.toH(), C.DWORD(hz)))
 }
 
+func (h handle) d2xxSetDataCharacteristics(bits, stop, parity byte) int {
+	return int(C.FT_SetDataCharacteristics(h.toH(), C.UCHAR(bits), C.UCHAR(stop), C.UCHAR(parity)))
+}
+
+func (h handle) d2xxSetBreak(on bool) int {
+	if on {
+		return int(C.FT_SetBreakOn(h.toH()))
+	}
+	return int(C.FT_SetBreakOff(h.toH()))
+}
+
+func (h handle) d2xxSetDtr(on bool) int {
+	if on {
+		return int(C.FT_SetDtr(h.toH()))
+	}
+	return int(C.FT_ClrDtr(h.toH()))
+}
+
+func (h handle) d2xxSetRts(on bool) int {
+	if on {
+		return int(C.FT_SetRts(h.toH()))
+	}
+	return int(C.FT_ClrRts(h.toH()))
+}
+
+func (h handle) d2xxGetModemStatus() (uint32, int) {
+	var v C.ULONG
+	e := C.FT_GetModemStatus(h.toH(), &v)
+	return uint32(v), int(e)
+}
+
 func (h handle) d2xxGetQueueStatus() (uint32, int) {
 	var v C.DWORD
 	e := C.FT_GetQueueStatus(h.toH(), &v)
 	return uint32(v), int(e)
 }
 
+func (h handle) d2xxGetStatus() (uint32, uint32, uint32, int) {
+	var rx, tx, ev C.DWORD
+	e := C.FT_GetStatus(h.toH(), &rx, &tx, &ev)
+	return uint32(rx), uint32(tx), uint32(ev), int(e)
+}
+
+// event is an event object armed by d2xxSetEventNotification. It is allocated
+// in C memory since the driver keeps a pointer to it.
+type event struct {
+	ev C.ft64Event
+	// users counts the goroutines waiting on ev, which d2xxClose waits for
+	// before freeing it.
+	users sync.WaitGroup
+}
+
+// events are the events of the open handles.
+var events = struct {
+	sync.Mutex
+	m map[handle]*event
+}{m: map[handle]*event{}}
+
+func (h handle) d2xxSetEventNotification() int {
+	events.Lock()
+	defer events.Unlock()
+	ev, ok := events.m[h]
+	if !ok {
+		ev = &event{ev: C.ft64NewEvent()}
+		if ev.ev == nil {
+			return 5 // FT_INSUFFICIENT_RESOURCES
+		}
+		events.m[h] = ev
+	}
+	return int(C.FT_SetEventNotification(h.toH(), C.FT_EVENT_RXCHAR|C.FT_EVENT_MODEM_STATUS, C.ft64EventArg(ev.ev)))
+}
+
+func (h handle) d2xxWaitEvent(timeoutMS uint32) (uint32, int) {
+	events.Lock()
+	ev, ok := events.m[h]
+	if ok {
+		ev.users.Add(1)
+	}
+	events.Unlock()
+	if !ok {
+		return 0, 1 // FT_INVALID_HANDLE
+	}
+	defer ev.users.Done()
+	var v C.DWORD
+	e := C.ft64WaitEvent(h.toH(), ev.ev, C.DWORD(timeoutMS), &v)
+	return uint32(v), int(e)
+}
+
 func (h handle) d2xxRead(b []byte) (int, int) {
 	var bytesRead C.DWORD
 	e := C.FT_Read(h.toH(), C.LPVOID(unsafe.Pointer(&b[0])), C.DWORD(len(b)), &bytesRead)
@@ -207,6 +523,17 @@ func (h handle) d2xxWrite(b []byte) (int, int) {
 	return int(bytesSent), int(e)
 }
 
+func (h handle) d2xxPurge(rx, tx bool) int {
+	mask := C.DWORD(0)
+	if rx {
+		mask |= C.FT_PURGE_RX
+	}
+	if tx {
+		mask |= C.FT_PURGE_TX
+	}
+	return int(C.FT_Purge(h.toH(), mask))
+}
+
 func (h handle) d2xxGetBitMode() (byte, int) {
 	var s C.UCHAR
 	e := C.FT_GetBitMode(h.toH(), &s)
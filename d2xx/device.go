@@ -0,0 +1,195 @@
+package d2xx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// Device is a FTDI device, or one channel of a multi-channel device, opened
+// through the D2XX driver.
+//
+// It is not safe for concurrent use.
+type Device struct {
+	*device
+}
+
+// Open opens the i-th device. opts can be nil.
+func Open(i int, opts *Options) (*Device, error) {
+	return openDevice(d2xxOpen, i, opts)
+}
+
+// OpenBySerial opens the device with the serial number. A channel of a
+// multi-channel device has its own serial number, e.g. "FT1234A". opts can
+// be nil.
+func OpenBySerial(serial string, opts *Options) (*Device, error) {
+	return openDevice(func(int) (d2xxHandle, int) {
+		return d2xxOpenEx(serial, openBySerial)
+	}, 0, opts)
+}
+
+// OpenByLocation opens the device with the USB location ID, which is stable
+// across re-enumerations as long as it stays on the same port. opts can be
+// nil.
+func OpenByLocation(loc uint32, opts *Options) (*Device, error) {
+	return openDevice(func(int) (d2xxHandle, int) {
+		return d2xxOpenEx(strconv.FormatUint(uint64(loc), 10), openByLocation)
+	}, 0, opts)
+}
+
+// OpenByDescription opens the device with the USB description, e.g.
+// "Dual RS232-HS A". opts can be nil.
+func OpenByDescription(desc string, opts *Options) (*Device, error) {
+	return openDevice(func(int) (d2xxHandle, int) {
+		return d2xxOpenEx(desc, openByDescription)
+	}, 0, opts)
+}
+
+// openDevice opens a device and applies the options.
+func openDevice(opener func(i int) (d2xxHandle, int), i int, opts *Options) (*Device, error) {
+	d, err := openDev(opener, i, opts)
+	if err != nil {
+		if d != nil {
+			d.closeDev()
+		}
+		return nil, err
+	}
+	if d.opts.Reset {
+		err = d.reset()
+	}
+	if err == nil {
+		err = d.setupCommon()
+	}
+	if err != nil {
+		d.closeDev()
+		return nil, err
+	}
+	return &Device{d}, nil
+}
+
+func (d *Device) String() string {
+	return fmt.Sprintf("%s(%s)", d.t, d.channel)
+}
+
+// DevInfo returns the device type, the vendor ID and the device ID.
+func (d *Device) DevInfo() (ftdi.DevType, uint16, uint16) {
+	return d.t, d.venID, d.devID
+}
+
+// Read implements io.Reader.
+//
+// It blocks until at least one byte is received. It fails with an error
+// wrapping os.ErrDeadlineExceeded if nothing is received within ReadTimeout;
+// a ReadTimeout of 0 blocks until data arrives.
+func (d *Device) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var deadline time.Time
+	if d.opts.ReadTimeout != 0 {
+		deadline = time.Now().Add(d.opts.ReadTimeout)
+	}
+	for {
+		var n int
+		var err error
+		if d.events {
+			wait := deadline
+			if wait.IsZero() {
+				wait = time.Now().Add(time.Second)
+			}
+			n, err = d.readWait(b, wait)
+		} else {
+			n, err = d.read(b)
+		}
+		if n != 0 || err != nil {
+			return n, err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return 0, fmt.Errorf("d2xx: Read: %w", os.ErrDeadlineExceeded)
+		}
+		if !d.events {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// Write implements io.Writer.
+//
+// On failure, the error is a *WriteError telling how many bytes were written.
+func (d *Device) Write(b []byte) (int, error) {
+	if err := d.writeAll(b); err != nil {
+		var we *WriteError
+		if errors.As(err, &we) {
+			return we.Written, err
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close implements io.Closer.
+//
+// The device is set back to its default mode before being closed. Closing it
+// again does nothing.
+func (d *Device) Close() error {
+	return d.resetAndClose()
+}
+
+// SetBitMode changes the mode of operation of the device.
+//
+// mask sets which pins are inputs and outputs, except for BitModeMpsse where
+// the direction is set by commands.
+func (d *Device) SetBitMode(mask byte, mode BitMode) error {
+	return d.setBitMode(mask, mode)
+}
+
+// Reset resets the device, then applies the options again.
+func (d *Device) Reset() error {
+	if err := d.reset(); err != nil {
+		return err
+	}
+	return d.setupCommon()
+}
+
+// Purge discards the data in the driver's RX and TX buffers.
+func (d *Device) Purge() error {
+	return d.purge()
+}
+
+// SetBaudRate sets the baud rate of the UART, or the clock of the bit-bang
+// modes.
+func (d *Device) SetBaudRate(hz int64) error {
+	return d.setBaudRate(hz)
+}
+
+// EEPROM reads the EEPROM content into ee.
+//
+// A blank EEPROM is returned as a valid empty content for the device type.
+func (d *Device) EEPROM(ee *ftdi.EEPROM) error {
+	return d.readEEPROM(ee)
+}
+
+// ProgramEEPROM writes ee to the EEPROM.
+func (d *Device) ProgramEEPROM(ee *ftdi.EEPROM) error {
+	return d.programEEPROM(ee)
+}
+
+// EraseEEPROM erases the EEPROM. It isn't supported by FT232R and FT245R.
+func (d *Device) EraseEEPROM() error {
+	return d.eraseEEPROM()
+}
+
+// UserArea returns the content of the EEPROM user area.
+func (d *Device) UserArea() ([]byte, error) {
+	return d.readUA()
+}
+
+// WriteUserArea writes the EEPROM user area. The content is zero padded to
+// the user area size.
+func (d *Device) WriteUserArea(ua []byte) error {
+	return d.writeUA(ua)
+}
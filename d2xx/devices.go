@@ -0,0 +1,55 @@
+package d2xx
+
+import (
+	"fmt"
+
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// DeviceInfo describes a device, or one channel of a multi-channel device, as
+// enumerated by the driver.
+type DeviceInfo struct {
+	// Index is the index passed to Open.
+	Index int
+	Type  ftdi.DevType
+	VenID uint16
+	DevID uint16
+	// Serial and Description are empty while the device is opened.
+	Serial      string
+	Description string
+	// LocID is the USB location ID. The channels of a chip have consecutive
+	// IDs.
+	LocID uint32
+	// Opened is true if the device is opened, by this process or another one.
+	Opened bool
+	// HiSpeed is true if the device is connected at USB 2.0 high speed.
+	HiSpeed bool
+}
+
+func (i *DeviceInfo) String() string {
+	s := fmt.Sprintf("%d: %s %04x:%04x serial=%q description=%q location=0x%x", i.Index, i.Type, i.VenID, i.DevID, i.Serial, i.Description, i.LocID)
+	if i.HiSpeed {
+		s += " hi-speed"
+	}
+	if i.Opened {
+		s += " opened"
+	}
+	return s
+}
+
+// ListDevices returns the devices currently connected.
+func ListDevices() ([]DeviceInfo, error) {
+	num, err := numDevices()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]DeviceInfo, 0, num)
+	for i := 0; i < num; i++ {
+		info, e := d2xxGetDeviceInfoDetail(i)
+		if e != 0 {
+			return nil, toErr("GetDeviceInfoDetail", e)
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
@@ -0,0 +1,149 @@
+package d2xx
+
+import (
+	"fmt"
+
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// Drive is the drive settings of a group of pins.
+type Drive struct {
+	// CurrentMA is the drive current: 4, 8, 12 or 16mA.
+	CurrentMA uint8 `json:"current_ma"`
+	SlowSlew  bool  `json:"slow_slew"`
+	Schmitt   bool  `json:"schmitt"`
+}
+
+func (d Drive) String() string {
+	s := fmt.Sprintf("%dmA", d.CurrentMA)
+	if d.SlowSlew {
+		s += ", slow slew"
+	}
+	if d.Schmitt {
+		s += ", schmitt"
+	}
+	return s
+}
+
+func (d Drive) validate(name string) error {
+	switch d.CurrentMA {
+	case 4, 8, 12, 16:
+		return nil
+	default:
+		return fmt.Errorf("d2xx: %s drive current must be 4, 8, 12 or 16mA, not %dmA", name, d.CurrentMA)
+	}
+}
+
+// DriveSettings are the drive settings of the pins of a FT2232H, stored in
+// its EEPROM: AL is ADBUS, AH ACBUS, BL BDBUS and BH BCBUS.
+type DriveSettings struct {
+	AL Drive `json:"al"`
+	AH Drive `json:"ah"`
+	BL Drive `json:"bl"`
+	BH Drive `json:"bh"`
+}
+
+func (s DriveSettings) String() string {
+	return fmt.Sprintf("AL %s; AH %s; BL %s; BH %s", s.AL, s.AH, s.BL, s.BH)
+}
+
+// Validate returns an error if a drive current isn't supported.
+func (s *DriveSettings) Validate() error {
+	for _, g := range s.groups() {
+		if err := g.d.validate(g.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type driveGroup struct {
+	name string
+	d    *Drive
+}
+
+func (s *DriveSettings) groups() []driveGroup {
+	return []driveGroup{{"AL", &s.AL}, {"AH", &s.AH}, {"BL", &s.BL}, {"BH", &s.BH}}
+}
+
+// driveFields returns the EEPROM fields of each group, in the order of
+// DriveSettings.groups.
+func driveFields(ee *ftdi.EEPROMFT2232H) [4][3]*uint8 {
+	return [4][3]*uint8{
+		{&ee.ALDriveCurrent, &ee.ALSlowSlew, &ee.ALSchmittInput},
+		{&ee.AHDriveCurrent, &ee.AHSlowSlew, &ee.AHSchmittInput},
+		{&ee.BLDriveCurrent, &ee.BLSlowSlew, &ee.BLSchmittInput},
+		{&ee.BHDriveCurrent, &ee.BHSlowSlew, &ee.BHSchmittInput},
+	}
+}
+
+func readDrive(ee *ftdi.EEPROMFT2232H) DriveSettings {
+	var s DriveSettings
+	f := driveFields(ee)
+	for i, g := range s.groups() {
+		g.d.CurrentMA = *f[i][0]
+		// a blank EEPROM drives the default 4mA
+		if g.d.CurrentMA == 0 {
+			g.d.CurrentMA = 4
+		}
+		g.d.SlowSlew = *f[i][1] != 0
+		g.d.Schmitt = *f[i][2] != 0
+	}
+	return s
+}
+
+func writeDrive(ee *ftdi.EEPROMFT2232H, s DriveSettings) {
+	f := driveFields(ee)
+	for i, g := range s.groups() {
+		*f[i][0] = g.d.CurrentMA
+		*f[i][1] = boolByte(g.d.SlowSlew)
+		*f[i][2] = boolByte(g.d.Schmitt)
+	}
+}
+
+func boolByte(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// DriveSettings reads the drive settings from the EEPROM of a FT2232H.
+func (d *Device) DriveSettings() (DriveSettings, error) {
+	var ee ftdi.EEPROM
+	e, err := d.ft2232hEEPROM(&ee)
+	if err != nil {
+		return DriveSettings{}, err
+	}
+	return readDrive(e), nil
+}
+
+// ProgramDriveSettings programs the drive settings in the EEPROM of a
+// FT2232H, keeping the other settings, and verifies them. It returns the
+// previous settings, to revert the change.
+//
+// The new settings are only used once the device enumerates again.
+func (d *Device) ProgramDriveSettings(s DriveSettings) (DriveSettings, error) {
+	if err := s.Validate(); err != nil {
+		return DriveSettings{}, err
+	}
+	var ee ftdi.EEPROM
+	e, err := d.ft2232hEEPROM(&ee)
+	if err != nil {
+		return DriveSettings{}, err
+	}
+	prev := readDrive(e)
+	writeDrive(e, s)
+	if err := d.programEEPROM(&ee); err != nil {
+		return prev, err
+	}
+	// verify
+	var got ftdi.EEPROM
+	if e, err = d.ft2232hEEPROM(&got); err != nil {
+		return prev, err
+	}
+	if v := readDrive(e); v != s {
+		return prev, fmt.Errorf("d2xx: EEPROM has drive settings %s after programming %s", v, s)
+	}
+	return prev, nil
+}
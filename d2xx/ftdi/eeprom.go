@@ -53,6 +53,15 @@ func (e *EEPROM) AsFT2232H() *EEPROMFT2232H {
 	return (*EEPROMFT2232H)(unsafe.Pointer(&e.Raw[0]))
 }
 
+// AsFT4232H returns the Raw data aliased as EEPROMFT4232H.
+func (e *EEPROM) AsFT4232H() *EEPROMFT4232H {
+	// sizeof(EEPROMFT4232H)
+	if len(e.Raw) < 36 {
+		return nil
+	}
+	return (*EEPROMFT4232H)(unsafe.Pointer(&e.Raw[0]))
+}
+
 // AsFT232R returns the Raw data aliased as EEPROMFT232R.
 func (e *EEPROM) AsFT232R() *EEPROMFT232R {
 	// sizeof(EEPROMFT232R)
@@ -259,6 +268,47 @@ type EEPROMFT2232H struct {
 	Unused3         uint16 // 0x26
 }
 
+// EEPROMFT4232H is the EEPROM layout of a FT4232H device.
+//
+// It is 36 bytes long.
+type EEPROMFT4232H struct {
+	EEPROMHeader
+
+	// FT4232H specific.
+	ASlowSlew     uint8 // 0x10 bool non-zero if A pins have slow slew
+	ASchmittInput uint8 // 0x11 bool non-zero if A pins are Schmitt input
+	ADriveCurrent uint8 // 0x12 Valid values are 4mA, 8mA, 12mA, 16mA in 2mA units
+	BSlowSlew     uint8 // 0x13 bool non-zero if B pins have slow slew
+	BSchmittInput uint8 // 0x14 bool non-zero if B pins are Schmitt input
+	BDriveCurrent uint8 // 0x15 Valid values are 4mA, 8mA, 12mA, 16mA in 2mA units
+	CSlowSlew     uint8 // 0x16 bool non-zero if C pins have slow slew
+	CSchmittInput uint8 // 0x17 bool non-zero if C pins are Schmitt input
+	CDriveCurrent uint8 // 0x18 Valid values are 4mA, 8mA, 12mA, 16mA in 2mA units
+	DSlowSlew     uint8 // 0x19 bool non-zero if D pins have slow slew
+	DSchmittInput uint8 // 0x1A bool non-zero if D pins are Schmitt input
+	DDriveCurrent uint8 // 0x1B Valid values are 4mA, 8mA, 12mA, 16mA in 2mA units
+	ARIIsTXDEN    uint8 // 0x1C bool non-zero if port A uses RI as RS485 TXDEN
+	BRIIsTXDEN    uint8 // 0x1D bool non-zero if port B uses RI as RS485 TXDEN
+	CRIIsTXDEN    uint8 // 0x1E bool non-zero if port C uses RI as RS485 TXDEN
+	DRIIsTXDEN    uint8 // 0x1F bool non-zero if port D uses RI as RS485 TXDEN
+	ADriverType   uint8 // 0x20 bool 0 is D2XX, 1 is VCP
+	BDriverType   uint8 // 0x21 bool 0 is D2XX, 1 is VCP
+	CDriverType   uint8 // 0x22 bool 0 is D2XX, 1 is VCP
+	DDriverType   uint8 // 0x23 bool 0 is D2XX, 1 is VCP
+}
+
+func (e *EEPROMFT4232H) Defaults() {
+	// As programmed by FTDI.
+	e.ADriveCurrent = 4
+	e.BDriveCurrent = 4
+	e.CDriveCurrent = 4
+	e.DDriveCurrent = 4
+	e.ADriverType = 1
+	e.BDriverType = 1
+	e.CDriverType = 1
+	e.DDriverType = 1
+}
+
 // EEPROMFT232R is the EEPROM layout of a FT232R device.
 //
 // It is 32 bytes long.
@@ -328,6 +378,9 @@ func (d DevType) EEPROMSize() int {
 	case FT2232H:
 		// sizeof(EEPROMFT2232H)
 		return 40
+	case FT4232H:
+		// sizeof(EEPROMFT4232H)
+		return 36
 	case FT232R:
 		// sizeof(EEPROMFT232R)
 		return 32
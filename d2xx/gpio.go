@@ -0,0 +1,198 @@
+package d2xx
+
+import "fmt"
+
+// Pin is a GPIO pin of a MPSSE channel.
+//
+// D0~D7 are the xDBUS pins accessed by the low byte commands 0x80/0x81, C0~C7
+// are the xCBUS pins accessed by the high byte commands 0x82/0x83.
+type Pin uint8
+
+const (
+	D0 Pin = iota // xDBUS0: TCK/SK
+	D1            // xDBUS1: TDI/DO
+	D2            // xDBUS2: TDO/DI
+	D3            // xDBUS3: TMS/CS
+	D4            // xDBUS4: GPIOL0
+	D5            // xDBUS5: GPIOL1
+	D6            // xDBUS6: GPIOL2
+	D7            // xDBUS7: GPIOL3
+	C0            // xCBUS0: GPIOH0
+	C1            // xCBUS1: GPIOH1
+	C2            // xCBUS2: GPIOH2
+	C3            // xCBUS3: GPIOH3
+	C4            // xCBUS4: GPIOH4
+	C5            // xCBUS5: GPIOH5
+	C6            // xCBUS6: GPIOH6
+	C7            // xCBUS7: GPIOH7
+)
+
+func (p Pin) String() string {
+	if p > C7 {
+		return fmt.Sprintf("Pin(%d)", p)
+	}
+	if p < C0 {
+		return fmt.Sprintf("D%d", p)
+	}
+	return fmt.Sprintf("C%d", p-C0)
+}
+
+// check returns an error if p isn't a pin of the channel.
+func (p Pin) check() error {
+	if p > C7 {
+		return fmt.Errorf("d2xx: invalid pin %s", p)
+	}
+	return nil
+}
+
+// bus returns 0 for xDBUS and 1 for xCBUS.
+func (p Pin) bus() int {
+	return int(p / 8)
+}
+
+func (p Pin) mask() byte {
+	return 1 << (p % 8)
+}
+
+// GPIO controls the 16 pins of a MPSSE channel.
+//
+// The direction and the output level of each pin are cached, so a pin can be
+// changed without clobbering the others. Changes are batched until Flush,
+// which writes at most one 0x80 and one 0x82 command.
+//
+// It is not safe for concurrent use.
+type GPIO struct {
+	dev      *device
+	value    [2]byte
+	dir      [2]byte
+	dirty    [2]bool
+	commands []byte
+}
+
+// OpenGPIO opens the i-th device with all its pins as inputs. opts can be nil.
+func OpenGPIO(i int, opts *Options) (*GPIO, error) {
+	dev, err := openMpsse(i, opts)
+	if err != nil {
+		return nil, err
+	}
+	g := &GPIO{dev: dev, dirty: [2]bool{true, true}}
+	if err := g.Flush(); err != nil {
+		g.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *GPIO) Close() {
+	if g.dev != nil {
+		g.dev.resetAndClose()
+		g.dev = nil
+	}
+}
+
+// SetDirection stages the direction of the pin p.
+func (g *GPIO) SetDirection(p Pin, out bool) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	b := p.bus()
+	if out {
+		g.dir[b] |= p.mask()
+	} else {
+		g.dir[b] &^= p.mask()
+	}
+	g.dirty[b] = true
+	return nil
+}
+
+// Set stages the output level of the pin p.
+//
+// The level is kept while the pin is an input and applied once it becomes an
+// output.
+func (g *GPIO) Set(p Pin, level bool) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	b := p.bus()
+	if level {
+		g.value[b] |= p.mask()
+	} else {
+		g.value[b] &^= p.mask()
+	}
+	g.dirty[b] = true
+	return nil
+}
+
+// Out sets the pin p as an output at the level and flushes immediately.
+func (g *GPIO) Out(p Pin, level bool) error {
+	if err := g.Set(p, level); err != nil {
+		return err
+	}
+	g.SetDirection(p, true)
+	return g.Flush()
+}
+
+// Direction returns true if the pin p is staged as an output.
+func (g *GPIO) Direction(p Pin) (bool, error) {
+	if err := p.check(); err != nil {
+		return false, err
+	}
+	return g.dir[p.bus()]&p.mask() != 0, nil
+}
+
+// Level returns the staged output level of the pin p.
+func (g *GPIO) Level(p Pin) (bool, error) {
+	if err := p.check(); err != nil {
+		return false, err
+	}
+	return g.value[p.bus()]&p.mask() != 0, nil
+}
+
+// Flush writes the staged changes to the device.
+func (g *GPIO) Flush() error {
+	g.commands = g.appendFlush(g.commands[:0])
+	if len(g.commands) == 0 {
+		return nil
+	}
+	if err := g.dev.send(g.commands); err != nil {
+		return err
+	}
+	g.dirty = [2]bool{}
+	return nil
+}
+
+// Read flushes the staged changes then returns the levels of all the pins;
+// D0~D7 in the low byte and C0~C7 in the high byte.
+func (g *GPIO) Read() (uint16, error) {
+	g.commands = g.appendFlush(g.commands[:0])
+	g.commands = append(g.commands,
+		0x81, // Read Data bits LowByte
+		0x83, // Read Data bits HighByte
+		0x87, // Send Immediate
+	)
+	b, err := g.dev.transfer(g.commands, 2)
+	if err != nil {
+		return 0, err
+	}
+	g.dirty = [2]bool{}
+	return uint16(b[1])<<8 | uint16(b[0]), nil
+}
+
+// Get returns the level of the pin p.
+func (g *GPIO) Get(p Pin) (bool, error) {
+	if err := p.check(); err != nil {
+		return false, err
+	}
+	v, err := g.Read()
+	return v&(1<<p) != 0, err
+}
+
+func (g *GPIO) appendFlush(cmd []byte) []byte {
+	if g.dirty[0] {
+		cmd = append(cmd, 0x80, g.value[0], g.dir[0]) // Set Data bits LowByte
+	}
+	if g.dirty[1] {
+		cmd = append(cmd, 0x82, g.value[1], g.dir[1]) // Set Data bits HighByte
+	}
+	return cmd
+}
@@ -0,0 +1,133 @@
+package d2xx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Identity describes a dumper board. It is stored in the EEPROM user area.
+type Identity struct {
+	Model    string
+	Revision uint8
+	// Profile is the name of the wiring profile of the board.
+	Profile string
+	// ClockHz is the calibrated bus clock, 0 for the default.
+	ClockHz uint32
+	// AddressWait is the ALE setup delay, in bytes clocked by the MPSSE, 0 for
+	// the default.
+	AddressWait uint16
+}
+
+// ErrNoIdentity is returned when the user area holds no identity.
+var ErrNoIdentity = errors.New("d2xx: no board identity")
+
+// identityMagic starts the identity record.
+const identityMagic = "FT64"
+
+// identityVersion is the version of the record written. Older versions must
+// stay readable.
+const identityVersion = 1
+
+func (id *Identity) String() string {
+	return fmt.Sprintf("%s rev %d, profile %s, clock %dHz, address wait %d", id.Model, id.Revision, id.Profile, id.ClockHz, id.AddressWait)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+//
+// The record is the magic, the version, the fixed size fields, the length
+// prefixed strings, then the CRC-32 of all of it. The integers are little
+// endian.
+func (id *Identity) MarshalBinary() ([]byte, error) {
+	if len(id.Model) > 255 || len(id.Profile) > 255 {
+		return nil, errors.New("d2xx: identity strings must be at most 255 bytes")
+	}
+	var b bytes.Buffer
+	b.WriteString(identityMagic)
+	b.WriteByte(identityVersion)
+	b.WriteByte(id.Revision)
+	binary.Write(&b, binary.LittleEndian, id.ClockHz)
+	binary.Write(&b, binary.LittleEndian, id.AddressWait)
+	for _, s := range []string{id.Model, id.Profile} {
+		b.WriteByte(byte(len(s)))
+		b.WriteString(s)
+	}
+	binary.Write(&b, binary.LittleEndian, crc32.ChecksumIEEE(b.Bytes()))
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. Trailing bytes, like
+// the padding of the user area, are ignored.
+func (id *Identity) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(identityMagic)) {
+		return ErrNoIdentity
+	}
+	invalid := errors.New("d2xx: invalid board identity")
+	r := bytes.NewReader(data[len(identityMagic):])
+	var hdr struct {
+		Version     uint8
+		Revision    uint8
+		ClockHz     uint32
+		AddressWait uint16
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return invalid
+	}
+	if hdr.Version == 0 || hdr.Version > identityVersion {
+		return fmt.Errorf("d2xx: board identity version %d isn't supported", hdr.Version)
+	}
+	var s [2]string
+	for i := range s {
+		l, err := r.ReadByte()
+		if err != nil || int(l) > r.Len() {
+			return invalid
+		}
+		b := make([]byte, l)
+		r.Read(b)
+		s[i] = string(b)
+	}
+	n := len(data) - r.Len()
+	var crc uint32
+	if err := binary.Read(r, binary.LittleEndian, &crc); err != nil || crc != crc32.ChecksumIEEE(data[:n]) {
+		return invalid
+	}
+	*id = Identity{
+		Model:       s[0],
+		Revision:    hdr.Revision,
+		Profile:     s[1],
+		ClockHz:     hdr.ClockHz,
+		AddressWait: hdr.AddressWait,
+	}
+	return nil
+}
+
+// readIdentity reads the identity from the user area.
+func (d *device) readIdentity() (*Identity, error) {
+	ua, err := d.readUA()
+	if err != nil {
+		return nil, err
+	}
+	id := &Identity{}
+	if err := id.UnmarshalBinary(ua); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// Identity returns the board identity stored in the EEPROM user area, or
+// ErrNoIdentity.
+func (d *Device) Identity() (*Identity, error) {
+	return d.readIdentity()
+}
+
+// WriteIdentity stores the board identity in the EEPROM user area, replacing
+// its whole content.
+func (d *Device) WriteIdentity(id *Identity) error {
+	b, err := id.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return d.writeUA(b)
+}
@@ -0,0 +1,409 @@
+package d2xx
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TAPState is a state of the JTAG TAP controller.
+type TAPState uint8
+
+const (
+	TestLogicReset TAPState = iota
+	RunTestIdle
+	SelectDRScan
+	CaptureDR
+	ShiftDR
+	Exit1DR
+	PauseDR
+	Exit2DR
+	UpdateDR
+	SelectIRScan
+	CaptureIR
+	ShiftIR
+	Exit1IR
+	PauseIR
+	Exit2IR
+	UpdateIR
+)
+
+const tapStateName = "TestLogicResetRunTestIdleSelectDRScanCaptureDRShiftDRExit1DRPauseDRExit2DRUpdateDRSelectIRScanCaptureIRShiftIRExit1IRPauseIRExit2IRUpdateIR"
+
+var tapStateIndex = [...]uint8{0, 14, 25, 37, 46, 53, 60, 67, 74, 82, 94, 103, 110, 117, 124, 131, 139}
+
+func (s TAPState) String() string {
+	if s >= TAPState(len(tapStateIndex)-1) {
+		return fmt.Sprintf("TAPState(%d)", s)
+	}
+	return tapStateName[tapStateIndex[s]:tapStateIndex[s+1]]
+}
+
+// stable returns true if the state can be held by clocking TCK.
+func (s TAPState) stable() bool {
+	return s == TestLogicReset || s == RunTestIdle || s == PauseDR || s == PauseIR
+}
+
+// tapNext is the TAP state transition table indexed by TMS.
+var tapNext = [...][2]TAPState{
+	TestLogicReset: {RunTestIdle, TestLogicReset},
+	RunTestIdle:    {RunTestIdle, SelectDRScan},
+	SelectDRScan:   {CaptureDR, SelectIRScan},
+	CaptureDR:      {ShiftDR, Exit1DR},
+	ShiftDR:        {ShiftDR, Exit1DR},
+	Exit1DR:        {PauseDR, UpdateDR},
+	PauseDR:        {PauseDR, Exit2DR},
+	Exit2DR:        {ShiftDR, UpdateDR},
+	UpdateDR:       {RunTestIdle, SelectDRScan},
+	SelectIRScan:   {CaptureIR, TestLogicReset},
+	CaptureIR:      {ShiftIR, Exit1IR},
+	ShiftIR:        {ShiftIR, Exit1IR},
+	Exit1IR:        {PauseIR, UpdateIR},
+	PauseIR:        {PauseIR, Exit2IR},
+	Exit2IR:        {ShiftIR, UpdateIR},
+	UpdateIR:       {RunTestIdle, SelectDRScan},
+}
+
+// tmsPath returns the shortest TMS sequence going from one state to another.
+//
+// The bits are sent LSB first. An empty sequence is returned when from and to
+// are the same state.
+func tmsPath(from, to TAPState) (bits uint32, n int) {
+	if from == to {
+		return 0, 0
+	}
+	if to == TestLogicReset {
+		// 5 TMS high always lead to Test-Logic-Reset, whatever the state is.
+		return 0x1f, 5
+	}
+	type path struct {
+		bits uint32
+		n    int
+	}
+	var seen [len(tapNext)]bool
+	seen[from] = true
+	queue := []TAPState{from}
+	paths := map[TAPState]path{from: {}}
+	for len(queue) != 0 {
+		s := queue[0]
+		queue = queue[1:]
+		p := paths[s]
+		for tms := 0; tms < 2; tms++ {
+			next := tapNext[s][tms]
+			if seen[next] {
+				continue
+			}
+			seen[next] = true
+			np := path{bits: p.bits | uint32(tms)<<p.n, n: p.n + 1}
+			if next == to {
+				return np.bits, np.n
+			}
+			paths[next] = np
+			queue = append(queue, next)
+		}
+	}
+	// The TAP state graph is strongly connected.
+	panic("unreachable")
+}
+
+// JTAG pins on a MPSSE channel:
+//
+// xDBUS0: TCK: OUT
+// xDBUS1: TDI: OUT
+// xDBUS2: TDO: IN
+// xDBUS3: TMS: OUT
+// xDBUS4: GPIOL0: IN
+// xDBUS5: GPIOL1: IN
+// xDBUS6: GPIOL2: IN
+// xDBUS7: GPIOL3: IN
+//
+// xCBUS0-7: GPIOH0-7: IN
+
+// JTAG drives a JTAG chain through the MPSSE engine of a channel.
+//
+// It is not safe for concurrent use.
+type JTAG struct {
+	dev      *device
+	state    TAPState
+	hz       int64
+	commands []byte
+}
+
+// OpenJTAG opens the i-th device as a JTAG master. opts can be nil.
+//
+// The TAP controller is brought to Test-Logic-Reset and TCK runs at 1MHz.
+func OpenJTAG(i int, opts *Options) (*JTAG, error) {
+	dev, err := openMpsse(i, opts)
+	if err != nil {
+		return nil, err
+	}
+	j := &JTAG{dev: dev, state: TestLogicReset}
+	if err := j.setupPins(); err != nil {
+		j.Close()
+		return nil, err
+	}
+	if _, err := j.SetFrequency(1000000); err != nil {
+		j.Close()
+		return nil, err
+	}
+	if err := j.Reset(); err != nil {
+		j.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *JTAG) Close() {
+	if j.dev != nil {
+		j.dev.resetAndClose()
+		j.dev = nil
+	}
+}
+
+// State returns the current state of the TAP controller.
+func (j *JTAG) State() TAPState {
+	return j.state
+}
+
+// SetFrequency sets the TCK frequency and returns the actual one.
+//
+// The actual frequency is the highest one which is not above hz.
+func (j *JTAG) SetFrequency(hz int64) (int64, error) {
+	c, err := NewClock(hz, false, false)
+	if err != nil {
+		return 0, err
+	}
+	if err := j.dev.setClock(c); err != nil {
+		return 0, err
+	}
+	j.hz = c.Hz()
+	return j.hz, nil
+}
+
+func (j *JTAG) setupPins() error {
+	j.commands = append(j.commands[:0],
+		0x80,
+		0b0000_1000, // TMS:1, TDO:0, TDI:0, TCK:0
+		0b0000_1011, // TMS:Out, TDO:In, TDI:Out, TCK:Out
+		0x82,
+		0x00, // GPIOH7-0:0
+		0x00, // GPIOH7-0:In
+	)
+	return j.dev.send(j.commands)
+}
+
+// Reset brings the TAP controller to Test-Logic-Reset.
+func (j *JTAG) Reset() error {
+	j.commands = appendTMS(j.commands[:0], 0x1f, 5, false)
+	if err := j.dev.send(j.commands); err != nil {
+		return err
+	}
+	j.state = TestLogicReset
+	return nil
+}
+
+// GotoState moves the TAP controller to the state s.
+func (j *JTAG) GotoState(s TAPState) error {
+	j.commands = j.appendGoto(j.commands[:0], s)
+	return j.dev.send(j.commands)
+}
+
+// RunTest moves the TAP controller to the stable state s and clocks TCK n
+// times while staying there.
+func (j *JTAG) RunTest(s TAPState, n int) error {
+	if !s.stable() {
+		return fmt.Errorf("d2xx: %s is not a stable state", s)
+	}
+	j.commands = j.appendGoto(j.commands[:0], s)
+	j.commands = appendClocks(j.commands, n, s == TestLogicReset)
+	return j.dev.send(j.commands)
+}
+
+// ShiftIR shifts bits from tdi into the instruction register and returns the
+// bits captured from TDO. The TAP controller is left in the state end.
+//
+// Bits are sent LSB first, starting from tdi[0].
+func (j *JTAG) ShiftIR(tdi []byte, bits int, end TAPState) ([]byte, error) {
+	return j.shift(ShiftIR, tdi, bits, end)
+}
+
+// ShiftDR shifts bits from tdi into the data register and returns the bits
+// captured from TDO. The TAP controller is left in the state end.
+//
+// Bits are sent LSB first, starting from tdi[0].
+func (j *JTAG) ShiftDR(tdi []byte, bits int, end TAPState) ([]byte, error) {
+	return j.shift(ShiftDR, tdi, bits, end)
+}
+
+// IDCodes returns the IDCODE of each device in the chain, starting from the
+// one closest to TDO.
+//
+// A device which selects BYPASS after reset is reported as 0.
+func (j *JTAG) IDCodes() ([]uint32, error) {
+	const maxDevices = 32
+	if err := j.Reset(); err != nil {
+		return nil, err
+	}
+	// Shift 1s through the data registers selected by the reset. Once all the
+	// registers are flushed, TDO returns what is shifted in.
+	bits := (maxDevices + 1) * 32
+	tdo, err := j.ShiftDR(ones(bits), bits, RunTestIdle)
+	if err != nil {
+		return nil, err
+	}
+	bit := func(i int) uint32 {
+		return uint32(tdo[i/8]>>(i%8)) & 1
+	}
+	var ids []uint32
+	for i := 0; i+32 <= bits; {
+		if bit(i) == 0 {
+			// 1 bit BYPASS register.
+			ids = append(ids, 0)
+			i++
+			continue
+		}
+		id := uint32(0)
+		for k := 0; k < 32; k++ {
+			id |= bit(i+k) << k
+		}
+		if id == 0xffffffff {
+			return ids, nil
+		}
+		ids = append(ids, id)
+		i += 32
+	}
+	return nil, errors.New("d2xx: JTAG chain is too long or broken")
+}
+
+func (j *JTAG) shift(s TAPState, tdi []byte, bits int, end TAPState) ([]byte, error) {
+	if bits <= 0 || len(tdi)*8 < bits {
+		return nil, fmt.Errorf("d2xx: invalid JTAG shift of %d bits from %d bytes", bits, len(tdi))
+	}
+	j.commands = j.appendGoto(j.commands[:0], s)
+
+	// All bits but the last one are shifted in the Shift-xR state.
+	full := (bits - 1) / 8
+	rem := (bits - 1) % 8
+	for i := 0; i < full; {
+		chunk := full - i
+		if chunk > 65536 {
+			chunk = 65536
+		}
+		j.commands = append(j.commands,
+			0x39, // Clock Data Bytes In and Out LSB first (out on -ve, in on +ve)
+			uint8(chunk-1),
+			uint8((chunk-1)>>8),
+		)
+		j.commands = append(j.commands, tdi[i:i+chunk]...)
+		i += chunk
+	}
+	if rem != 0 {
+		j.commands = append(j.commands,
+			0x3b, // Clock Data Bits In and Out LSB first (out on -ve, in on +ve)
+			uint8(rem-1),
+			tdi[full],
+		)
+	}
+	// The last bit is shifted while leaving the Shift-xR state.
+	last := tdi[(bits-1)/8] >> ((bits - 1) % 8) & 1
+	j.commands = append(j.commands,
+		0x6b, // Clock Data to TMS pin with read
+		0,    // 1 bit
+		last<<7|1,
+	)
+	j.state = tapNext[s][1]
+	j.commands = j.appendGoto(j.commands, end)
+	j.commands = append(j.commands, 0x87) // Send Immediate
+
+	n := full + 1
+	if rem != 0 {
+		n++
+	}
+	resp, err := j.dev.transfer(j.commands, n)
+	if err != nil {
+		return nil, err
+	}
+
+	// The bits clocked in are shifted from the MSB.
+	tdo := make([]byte, (bits+7)/8)
+	copy(tdo, resp[:full])
+	if rem != 0 {
+		tdo[full] = resp[full] >> (8 - rem)
+	}
+	tdo[(bits-1)/8] |= resp[n-1] >> 7 << ((bits - 1) % 8)
+	return tdo, nil
+}
+
+// ones returns a vector of bits all set.
+func ones(bits int) []byte {
+	b := make([]byte, (bits+7)/8)
+	for i := 0; i < bits; i++ {
+		b[i/8] |= 1 << (i % 8)
+	}
+	return b
+}
+
+// appendGoto appends the commands moving the TAP controller to the state s.
+func (j *JTAG) appendGoto(cmd []byte, s TAPState) []byte {
+	bits, n := tmsPath(j.state, s)
+	j.state = s
+	return appendTMS(cmd, bits, n, false)
+}
+
+// appendTMS appends the commands clocking n bits of tms, LSB first, while
+// holding TDI.
+func appendTMS(cmd []byte, tms uint32, n int, tdi bool) []byte {
+	b7 := uint8(0)
+	if tdi {
+		b7 = 0x80
+	}
+	for n > 0 {
+		// At most 7 bits per command, bit 7 is TDI.
+		chunk := n
+		if chunk > 7 {
+			chunk = 7
+		}
+		cmd = append(cmd,
+			0x4b, // Clock Data to TMS pin (no read)
+			uint8(chunk-1),
+			b7|uint8(tms)&(1<<chunk-1),
+		)
+		tms >>= chunk
+		n -= chunk
+	}
+	return cmd
+}
+
+// appendClocks appends the commands clocking TCK n times with TMS held.
+func appendClocks(cmd []byte, n int, tms bool) []byte {
+	if tms {
+		// Clocking TMS explicitly is cheap enough for the few clocks needed in
+		// Test-Logic-Reset.
+		for ; n > 0; n -= 32 {
+			chunk := n
+			if chunk > 32 {
+				chunk = 32
+			}
+			cmd = appendTMS(cmd, 1<<chunk-1, chunk, false)
+		}
+		return cmd
+	}
+	for n >= 8 {
+		chunk := n / 8
+		if chunk > 65536 {
+			chunk = 65536
+		}
+		cmd = append(cmd,
+			0x8f, // Clock For n x 8 bits with no data transfer
+			uint8(chunk-1),
+			uint8((chunk-1)>>8),
+		)
+		n -= chunk * 8
+	}
+	if n != 0 {
+		cmd = append(cmd,
+			0x8e, // Clock For n bits with no data transfer
+			uint8(n-1),
+		)
+	}
+	return cmd
+}
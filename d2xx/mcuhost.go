@@ -0,0 +1,107 @@
+package d2xx
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// MCUHost is a FT2232H in MCU host bus emulation mode.
+//
+// The chip drives a 8051-like multiplexed bus by itself: AD7-0 on ADBUS, A15-8
+// on ACBUS, and CS#, ALE, RD#, WR# and IORDY on BCBUS. It uses the pins of
+// both channels, so channel B can't be used at the same time.
+//
+// It is safe for concurrent use.
+type MCUHost struct {
+	dev *device
+}
+
+// OpenMCUHost opens the i-th device, channel A of a FT2232H, in MCU host bus
+// emulation mode. opts can be nil.
+func OpenMCUHost(i int, opts *Options) (*MCUHost, error) {
+	d, err := openDev(d2xxOpen, i, opts)
+	if err != nil {
+		if d != nil {
+			d.closeDev()
+		}
+		return nil, err
+	}
+	if d.t != ftdi.FT2232H {
+		d.closeDev()
+		return nil, fmt.Errorf("device %d is %s which has no MCU host bus emulation", i, d.t)
+	}
+	if d.opts.Reset {
+		err = d.reset()
+	}
+	if err == nil {
+		err = d.setupCommon()
+	}
+	if err == nil {
+		err = d.setBitMode(0, BitModeMcuHost)
+	}
+	if err == nil {
+		time.Sleep(50 * time.Millisecond)
+		err = d.syncMpsse()
+	}
+	if err != nil {
+		d.closeDev()
+		return nil, err
+	}
+	return &MCUHost{dev: d}, nil
+}
+
+func (m *MCUHost) Close() {
+	if m.dev != nil {
+		m.dev.resetAndClose()
+		m.dev = nil
+	}
+}
+
+// Read reads the byte at addr.
+func (m *MCUHost) Read(addr uint16) (byte, error) {
+	b, err := m.ReadBlock(addr, 1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// ReadBlock reads n bytes from addr upward in a single batch of bus cycles.
+func (m *MCUHost) ReadBlock(addr uint16, n int) ([]byte, error) {
+	return m.ReadBlockAsync(addr, n)()
+}
+
+// ReadBlockAsync queues the read of n bytes from addr upward and returns a
+// function waiting for them.
+func (m *MCUHost) ReadBlockAsync(addr uint16, n int) func() ([]byte, error) {
+	cmd := make([]byte, 0, 3*n+1)
+	for i := 0; i < n; i++ {
+		cmd = appendMCURead(cmd, addr+uint16(i))
+	}
+	cmd = append(cmd, 0x87)
+	return m.dev.submit(cmd, n).wait
+}
+
+// Write writes v at addr.
+func (m *MCUHost) Write(addr uint16, v byte) error {
+	return m.dev.send(appendMCUWrite(nil, addr, v))
+}
+
+// appendMCURead appends the bus cycle reading one byte at addr. The short
+// form only drives AD7-0.
+func appendMCURead(cmd []byte, addr uint16) []byte {
+	if addr < 0x100 {
+		return append(cmd, 0x90, byte(addr))
+	}
+	return append(cmd, 0x91, byte(addr>>8), byte(addr))
+}
+
+// appendMCUWrite appends the bus cycle writing v at addr.
+func appendMCUWrite(cmd []byte, addr uint16, v byte) []byte {
+	if addr < 0x100 {
+		return append(cmd, 0x92, byte(addr), v)
+	}
+	return append(cmd, 0x93, byte(addr>>8), byte(addr), v)
+}
@@ -0,0 +1,280 @@
+package d2xx
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// openMpsse opens the i-th device and switches it to MPSSE mode.
+func openMpsse(i int, opts *Options) (*device, error) {
+	d, err := openDev(d2xxOpen, i, opts)
+	if err != nil {
+		return nil, err
+	}
+	switch d.t {
+	case ftdi.FT2232H, ftdi.FT4232H, ftdi.FT232H:
+	default:
+		d.closeDev()
+		return nil, fmt.Errorf("device %d is %s which has no MPSSE", i, d.t)
+	}
+	if err := d.setupMpsse(); err != nil {
+		d.closeDev()
+		return nil, err
+	}
+	return d, nil
+}
+
+// setupMpsse resets the device, unless disabled by the options, and switches
+// it to MPSSE mode.
+func (d *device) setupMpsse() error {
+	if d.opts.Reset {
+		if err := d.reset(); err != nil {
+			return err
+		}
+	}
+	if err := d.setupCommon(); err != nil {
+		return err
+	}
+	if err := d.setBitMode(0, BitModeMpsse); err != nil {
+		return err
+	}
+	time.Sleep(50 * time.Millisecond)
+	return d.tryMpsse()
+}
+
+// tryMpsse checks the MPSSE engine answers and synchronizes with it.
+func (d *device) tryMpsse() error {
+	var buf [4]byte
+
+	// Enable loopback
+	buf[0] = 0x84
+	sent, err := d.write(buf[:1])
+	if err != nil {
+		return err
+	}
+	if sent != 1 {
+		return fmt.Errorf("failed to write command: 0x%02x", buf[0])
+	}
+	// Check the receive buffer is empty
+	n, err := d.read(buf[2:3])
+	if n != 0 || err != nil {
+		return fmt.Errorf("MPSSE receive buffer should be empty: n=%d, err=%w", n, err)
+	}
+
+	// Synchronize the MPSSE
+	buf[0] = 0xab // bogus command
+	_, err = d.write(buf[:1])
+	for n == 0 && err == nil {
+		n, err = d.read(buf[2:4])
+	}
+	if err != nil {
+		return err
+	}
+	if n != 2 || buf[2] != 0xfa || buf[3] != 0xab {
+		return fmt.Errorf("failed to synchronize the MPSSE")
+	}
+
+	// Disable loopback
+	buf[0] = 0x85
+	sent, err = d.write(buf[:1])
+	if err != nil {
+		return err
+	}
+	if sent != 1 {
+		return fmt.Errorf("failed to write command: 0x%02x", buf[0])
+	}
+	// Check the receive buffer is empty
+	n, err = d.read(buf[2:3])
+	if n != 0 || err != nil {
+		return fmt.Errorf("MPSSE receive buffer should be empty: n=%d, err=%w", n, err)
+	}
+
+	return nil
+}
+
+// BadCommandError is returned when the MPSSE engine of a channel rejected a
+// command.
+type BadCommandError struct {
+	Channel string
+	Opcode  byte
+}
+
+func (e *BadCommandError) Error() string {
+	return fmt.Sprintf("d2xx: channel %s: MPSSE bad command 0x%02x", e.Channel, e.Opcode)
+}
+
+// mpsseValidOpcode returns true if op is a command known by the MPSSE engine.
+func mpsseValidOpcode(op byte) bool {
+	switch {
+	case op >= 0x10 && op <= 0x3f:
+		// Data shifting.
+		return true
+	case op == 0x4a || op == 0x4b || op == 0x6a || op == 0x6b || op == 0x6e || op == 0x6f:
+		// TMS.
+		return true
+	case op >= 0x80 && op <= 0x8f:
+		// GPIO, loopback, clock and wait.
+		return true
+	case op >= 0x90 && op <= 0x93:
+		// MCU host bus emulation.
+		return true
+	case op >= 0x94 && op <= 0x97, op >= 0x9c && op <= 0x9e:
+		return true
+	default:
+		return false
+	}
+}
+
+// mpsseMarker is appended to each batch of commands. The MPSSE answers 0xFA
+// followed by the opcode to a bad command, so the echo of the bogus 0xAB ends
+// every response, flushed by the send immediate.
+var mpsseMarker = []byte{0xab, 0x87}
+
+// withMarker returns a copy of cmd followed by mpsseMarker. The copy leaves
+// cmd untouched, as it may be shared by concurrent batches.
+func withMarker(cmd []byte) []byte {
+	return append(append(make([]byte, 0, len(cmd)+len(mpsseMarker)), cmd...), mpsseMarker...)
+}
+
+// readMpsse reads the response to a batch of MPSSE commands sent with
+// withMarker, and the echo of the marker.
+//
+// A bad command adds its own echo, so the marker isn't where expected. The
+// response is then read up to the marker echo and looked for the echo of the
+// bad command, and a *BadCommandError is returned. The engine must be
+// resynchronized after an error.
+func (d *device) readMpsse(b []byte) error {
+	all := make([]byte, len(b)+2, len(b)+64)
+	if err := d.readAll(all); err != nil {
+		return err
+	}
+	if all[len(b)] == 0xfa && all[len(b)+1] == 0xab {
+		copy(b, all)
+		return nil
+	}
+	// read up to the marker echo
+	var c [1]byte
+	for !(all[len(all)-2] == 0xfa && all[len(all)-1] == 0xab) {
+		if err := d.readAll(c[:]); err != nil {
+			break
+		}
+		all = append(all, c[0])
+	}
+	for i := 0; i+1 < len(all); i++ {
+		if all[i] == 0xfa && all[i+1] != 0xab && !mpsseValidOpcode(all[i+1]) {
+			return &BadCommandError{Channel: d.channel, Opcode: all[i+1]}
+		}
+	}
+	return fmt.Errorf("d2xx: channel %s: %d unexpected bytes in MPSSE response", d.channel, len(all)-len(b)-2)
+}
+
+// syncMpsse discards any pending data and waits for the MPSSE engine to echo a
+// bogus command.
+//
+// The bogus command differs from the one of mpsseMarker, so that the echoes of
+// the batches still executed by the engine are skipped.
+func (d *device) syncMpsse() error {
+	if err := d.purge(); err != nil {
+		return err
+	}
+	b := [1]byte{0xaa} // bogus command
+	if err := d.writeAll(b[:]); err != nil {
+		return err
+	}
+	prev := byte(0)
+	for {
+		if err := d.readAll(b[:]); err != nil {
+			return fmt.Errorf("d2xx: channel %s: failed to synchronize the MPSSE: %w", d.channel, err)
+		}
+		if prev == 0xfa && b[0] == 0xaa {
+			return nil
+		}
+		prev = b[0]
+	}
+}
+
+// Clock is the clock configuration of a MPSSE channel.
+type Clock struct {
+	// Div5 selects the 12MHz master clock instead of 60MHz.
+	Div5 bool
+	// Divisor divides the master clock by (1+Divisor)*2, or (1+Divisor)*3 with
+	// ThreePhase.
+	Divisor uint16
+	// Adaptive waits for RTCK on GPIOL3 after each TCK edge, for ARM JTAG
+	// targets.
+	Adaptive bool
+	// ThreePhase makes data valid on both clock edges, as needed by I2C. It
+	// lengthens each clock period by half.
+	ThreePhase bool
+}
+
+// NewClock returns the configuration with the highest frequency which is not
+// above hz.
+func NewClock(hz int64, adaptive, threePhase bool) (Clock, error) {
+	c := Clock{Adaptive: adaptive, ThreePhase: threePhase}
+	if hz <= 0 {
+		return c, fmt.Errorf("d2xx: invalid clock frequency %dHz", hz)
+	}
+	if hz > c.master()/c.phases() {
+		return c, fmt.Errorf("d2xx: clock frequency %dHz is too high", hz)
+	}
+	for _, div5 := range []bool{false, true} {
+		c.Div5 = div5
+		step := c.phases() * hz
+		if den := (c.master() + step - 1) / step; den <= 0x10000 {
+			c.Divisor = uint16(den - 1)
+			return c, nil
+		}
+	}
+	return c, fmt.Errorf("d2xx: clock frequency %dHz is too low", hz)
+}
+
+// Hz returns the clock frequency, rounded down to the Hz.
+func (c Clock) Hz() int64 {
+	return c.master() / ((1 + int64(c.Divisor)) * c.phases())
+}
+
+func (c Clock) master() int64 {
+	if c.Div5 {
+		return 12000000
+	}
+	return 60000000
+}
+
+func (c Clock) phases() int64 {
+	if c.ThreePhase {
+		return 3
+	}
+	return 2
+}
+
+// appendCommands appends the commands programming the clock.
+func (c Clock) appendCommands(cmd []byte) []byte {
+	if c.Div5 {
+		cmd = append(cmd, 0x8b) // Use 12MHz master clock
+	} else {
+		cmd = append(cmd, 0x8a) // Use 60MHz master clock
+	}
+	if c.Adaptive {
+		cmd = append(cmd, 0x96) // Turn on adaptive clocking
+	} else {
+		cmd = append(cmd, 0x97) // Turn off adaptive clocking
+	}
+	if c.ThreePhase {
+		cmd = append(cmd, 0x8c) // Enable three-phase clocking
+	} else {
+		cmd = append(cmd, 0x8d) // Disable three-phase clocking
+	}
+	return append(cmd,
+		0x86, // set clock divisor
+		uint8(c.Divisor),
+		uint8(c.Divisor>>8),
+	)
+}
+
+// setClock programs the clock of the channel.
+func (d *device) setClock(c Clock) error {
+	return d.send(c.appendCommands(nil))
+}
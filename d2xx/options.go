@@ -0,0 +1,99 @@
+package d2xx
+
+import (
+	"errors"
+	"time"
+)
+
+// FlowControl is the flow control used by a channel.
+type FlowControl uint16
+
+const (
+	FlowNone    FlowControl = 0x0000
+	FlowRTSCTS  FlowControl = 0x0100
+	FlowDTRDSR  FlowControl = 0x0200
+	FlowXOnXOff FlowControl = 0x0400
+)
+
+// Options are the driver settings applied to a device when it is opened.
+//
+// Start from DefaultOptions() and change the fields needed; a nil *Options
+// means the defaults.
+type Options struct {
+	// USBInTransferSize is the USB request size for IN transfers, a multiple of
+	// 64 between 64 and 65536. Setting it clears any data in the buffer.
+	//
+	// Default: 65536.
+	USBInTransferSize int
+	// USBOutTransferSize is the USB request size for OUT transfers. The driver
+	// ignores it.
+	//
+	// Default: 0.
+	USBOutTransferSize int
+	// ReadTimeout and WriteTimeout are the driver I/O timeouts, in ms
+	// granularity. They are long so that timeouts are very visible.
+	// ReadTimeout also bounds Device.Read, 0 meaning it blocks until data
+	// arrives. WriteTimeout also bounds the time to write a whole batch, 0
+	// meaning no limit as long as the driver makes progress.
+	//
+	// Default: 15s.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// LatencyTimer is how long the device waits before sending a partial USB
+	// packet, between 1ms and 255ms.
+	//
+	// Default: 1ms.
+	LatencyTimer time.Duration
+	// FlowControl synchronizes the IN requests. XOn and XOff are only used with
+	// FlowXOnXOff.
+	//
+	// Default: FlowRTSCTS.
+	FlowControl FlowControl
+	XOn         byte
+	XOff        byte
+	// EventChar and ErrorChar are only used when enabled.
+	//
+	// Default: disabled.
+	EventChar        byte
+	EventCharEnabled bool
+	ErrorChar        byte
+	ErrorCharEnabled bool
+	// Reset resets the device when opened. Skipping it reduces the glitches on
+	// the pins but the device state is unknown.
+	//
+	// Default: true.
+	Reset bool
+}
+
+// DefaultOptions returns the options used when none are specified.
+func DefaultOptions() *Options {
+	return &Options{
+		USBInTransferSize: 65536,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		LatencyTimer:      time.Millisecond,
+		FlowControl:       FlowRTSCTS,
+		Reset:             true,
+	}
+}
+
+func (o *Options) validate() error {
+	if o.USBInTransferSize < 64 || o.USBInTransferSize > 65536 || o.USBInTransferSize%64 != 0 {
+		return errors.New("d2xx: USBInTransferSize must be a multiple of 64 between 64 and 65536")
+	}
+	if o.USBOutTransferSize < 0 || o.USBOutTransferSize > 65536 {
+		return errors.New("d2xx: USBOutTransferSize must be between 0 and 65536")
+	}
+	if o.ReadTimeout < 0 || o.WriteTimeout < 0 {
+		return errors.New("d2xx: timeouts must not be negative")
+	}
+	if o.LatencyTimer < time.Millisecond || o.LatencyTimer > 255*time.Millisecond {
+		return errors.New("d2xx: LatencyTimer must be between 1ms and 255ms")
+	}
+	switch o.FlowControl {
+	case FlowNone, FlowRTSCTS, FlowDTRDSR, FlowXOnXOff:
+	default:
+		return errors.New("d2xx: invalid FlowControl")
+	}
+	return nil
+}
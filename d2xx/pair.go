@@ -0,0 +1,111 @@
+package d2xx
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// chipSerial returns the serial number of the chip of a channel, e.g.
+// "FT1234" for "FT1234A", and the channel letter.
+func chipSerial(serial string) (string, byte) {
+	if len(serial) < 2 {
+		return "", 0
+	}
+	return serial[:len(serial)-1], serial[len(serial)-1]
+}
+
+// checkPair returns an error if a and b aren't the channels A and B of the
+// same FT2232H.
+//
+// The driver gives each channel the chip serial number and description with
+// an "A" or "B" suffix, and consecutive location IDs. A chip without EEPROM
+// has no serial number, so its channels are paired by location only.
+func checkPair(a, b *DeviceInfo) error {
+	for _, d := range []*DeviceInfo{a, b} {
+		if d.Type != ftdi.FT2232H {
+			return fmt.Errorf("d2xx: device %d is %s, not %s", d.Index, d.Type, ftdi.FT2232H)
+		}
+		if d.Opened {
+			return fmt.Errorf("d2xx: device %d is already opened", d.Index)
+		}
+	}
+	if a.Serial != "" || b.Serial != "" {
+		sa, ca := chipSerial(a.Serial)
+		sb, cb := chipSerial(b.Serial)
+		if ca != 'A' || cb != 'B' || sa != sb {
+			return fmt.Errorf("d2xx: serial numbers %q and %q aren't the channels A and B of a chip", a.Serial, b.Serial)
+		}
+	} else if a.LocID == 0 {
+		return fmt.Errorf("d2xx: devices %d and %d have neither serial number nor location ID", a.Index, b.Index)
+	}
+	if !strings.HasSuffix(a.Description, " A") || !strings.HasSuffix(b.Description, " B") ||
+		strings.TrimSuffix(a.Description, " A") != strings.TrimSuffix(b.Description, " B") {
+		return fmt.Errorf("d2xx: descriptions %q and %q aren't the channels A and B of a chip", a.Description, b.Description)
+	}
+	if a.LocID != 0 && b.LocID != a.LocID+1 {
+		return fmt.Errorf("d2xx: location IDs 0x%x and 0x%x aren't consecutive", a.LocID, b.LocID)
+	}
+	return nil
+}
+
+// related returns true if a and b look like channels of the same chip, so a
+// failed checkPair is worth reporting.
+func related(a, b *DeviceInfo) bool {
+	sa, _ := chipSerial(a.Serial)
+	sb, _ := chipSerial(b.Serial)
+	return (sa != "" && sa == sb) || (a.LocID != 0 && b.LocID == a.LocID+1)
+}
+
+// findPair returns the channels A and B of the first chip accepted by match.
+// A nil match accepts any chip.
+func findPair(devs []DeviceInfo, match func(a *DeviceInfo) bool) (*DeviceInfo, *DeviceInfo, error) {
+	var err error
+	for i := range devs {
+		a := &devs[i]
+		if _, c := chipSerial(a.Serial); c == 'B' || strings.HasSuffix(a.Description, " B") {
+			continue
+		}
+		if a.Type != ftdi.FT2232H || (match != nil && !match(a)) {
+			continue
+		}
+		for j := range devs {
+			b := &devs[j]
+			if i == j || !related(a, b) {
+				continue
+			}
+			e := checkPair(a, b)
+			if e == nil {
+				return a, b, nil
+			}
+			if err == nil {
+				err = e
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("d2xx: channel B of device %d not found", a.Index)
+		}
+	}
+	if err == nil {
+		err = errors.New("d2xx: no FT2232H found")
+	}
+	return nil, nil, err
+}
+
+// FindROMs returns the channel A of each FT2232H which can be opened as a
+// cartridge dumper, with OpenROMBySerial or OpenROMByLocation.
+func FindROMs() ([]DeviceInfo, error) {
+	devs, err := ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	var out []DeviceInfo
+	for i := range devs {
+		if a, _, err := findPair(devs, func(a *DeviceInfo) bool { return a == &devs[i] }); err == nil {
+			out = append(out, *a)
+		}
+	}
+	return out, nil
+}
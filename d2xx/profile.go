@@ -0,0 +1,195 @@
+package d2xx
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Signal is a pin of a board.
+type Signal struct {
+	// Channel is the role of the channel: "A" drives the strobes, "B" waits on
+	// them.
+	Channel string `json:"channel"`
+	// Bus is "D" for xDBUS or "C" for xCBUS.
+	Bus string `json:"bus"`
+	Bit uint8  `json:"bit"`
+	// Idle is the level of an output once the pins are set up.
+	Idle bool `json:"idle"`
+}
+
+func (s Signal) String() string {
+	return fmt.Sprintf("%s%sBUS%d", s.Channel, s.Bus, s.Bit)
+}
+
+// role returns 0 for the channel A and 1 for B.
+func (s Signal) role() int {
+	if s.Channel == "B" {
+		return 1
+	}
+	return 0
+}
+
+// bus returns 0 for xDBUS and 1 for xCBUS.
+func (s Signal) bus() int {
+	if s.Bus == "C" {
+		return 1
+	}
+	return 0
+}
+
+func (s Signal) mask() byte {
+	return 1 << s.Bit
+}
+
+// Port is a whole 8 bits bus of a board.
+type Port struct {
+	Channel string `json:"channel"`
+	Bus     string `json:"bus"`
+}
+
+func (p Port) signal() Signal {
+	return Signal{Channel: p.Channel, Bus: p.Bus}
+}
+
+// Profile is the wiring of a cartridge dumper board.
+//
+// The cartridge driver generates its pin writes from it. The signals are
+// active high except /RE and /WE.
+type Profile struct {
+	Name string `json:"name"`
+	ALEH Signal `json:"ale_h"`
+	ALEL Signal `json:"ale_l"`
+	RE   Signal `json:"re"`
+	WE   Signal `json:"we"`
+	// CS is pulsed by the channel A after each step, for the channel B to wait
+	// on it through WAIT.
+	CS   Signal `json:"cs"`
+	RST  Signal `json:"rst"`
+	CLK  Signal `json:"clk"`
+	WAIT Signal `json:"wait"`
+	// ADLow and ADHigh are the multiplexed address/data bus, AD7-0 and AD15-8.
+	ADLow  Port `json:"ad_low"`
+	ADHigh Port `json:"ad_high"`
+	// Static are other outputs, left at their idle level. The pins not listed
+	// are inputs.
+	Static []Signal `json:"static"`
+}
+
+// outputs returns the signals driven by the board.
+func (p *Profile) outputs() []Signal {
+	return append([]Signal{p.ALEH, p.ALEL, p.RE, p.WE, p.CS, p.RST, p.CLK}, p.Static...)
+}
+
+// Validate returns an error if the cartridge driver can't use the wiring.
+func (p *Profile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("d2xx: profile has no name")
+	}
+	var used [2][2]byte
+	claim := func(name string, s Signal, mask byte) error {
+		if (s.Channel != "A" && s.Channel != "B") || (s.Bus != "D" && s.Bus != "C") || s.Bit > 7 {
+			return fmt.Errorf("d2xx: profile %s: %s is on an invalid pin %s", p.Name, name, s)
+		}
+		if used[s.role()][s.bus()]&mask != 0 {
+			return fmt.Errorf("d2xx: profile %s: %s is on a pin already used", p.Name, name)
+		}
+		used[s.role()][s.bus()] |= mask
+		return nil
+	}
+	for _, s := range []struct {
+		name string
+		s    Signal
+	}{
+		{"ale_h", p.ALEH}, {"ale_l", p.ALEL}, {"re", p.RE}, {"we", p.WE},
+		{"cs", p.CS}, {"rst", p.RST}, {"clk", p.CLK}, {"wait", p.WAIT},
+	} {
+		if err := claim(s.name, s.s, s.s.mask()); err != nil {
+			return err
+		}
+	}
+	for i, s := range p.Static {
+		if err := claim(fmt.Sprintf("static[%d]", i), s, s.mask()); err != nil {
+			return err
+		}
+	}
+	if err := claim("ad_low", p.ADLow.signal(), 0xff); err != nil {
+		return err
+	}
+	if err := claim("ad_high", p.ADHigh.signal(), 0xff); err != nil {
+		return err
+	}
+	// The channel A sequences the strobes and the channel B waits with the
+	// MPSSE "wait on I/O high" command, which only watches GPIOL1.
+	for _, s := range []Signal{p.ALEH, p.ALEL, p.RE, p.WE, p.CS} {
+		if s.Channel != "A" {
+			return fmt.Errorf("d2xx: profile %s: the strobes must be on the channel A", p.Name)
+		}
+	}
+	if p.WAIT.Channel != "B" || p.WAIT.Bus != "D" || p.WAIT.Bit != 5 {
+		return fmt.Errorf("d2xx: profile %s: wait must be BDBUS5 (GPIOL1)", p.Name)
+	}
+	if p.ADLow.Channel == p.ADHigh.Channel {
+		return fmt.Errorf("d2xx: profile %s: ad_low and ad_high must be on different channels", p.Name)
+	}
+	return nil
+}
+
+// LoadProfile reads a profile in JSON and validates it.
+func LoadProfile(r io.Reader) (*Profile, error) {
+	p := &Profile{}
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(p); err != nil {
+		return nil, fmt.Errorf("d2xx: invalid profile: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+//go:embed profiles/n64.json
+var n64ProfileJSON string
+
+var profiles = struct {
+	sync.Mutex
+	m map[string]*Profile
+}{m: map[string]*Profile{}}
+
+// DefaultProfile is the name of the profile of the original n64 board.
+const DefaultProfile = "n64"
+
+func init() {
+	p, err := LoadProfile(strings.NewReader(n64ProfileJSON))
+	if err != nil {
+		panic(err)
+	}
+	profiles.m[p.Name] = p
+}
+
+// RegisterProfile makes a profile available by name, replacing any profile
+// with the same name.
+func RegisterProfile(p *Profile) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	profiles.Lock()
+	defer profiles.Unlock()
+	profiles.m[p.Name] = p
+	return nil
+}
+
+// LookupProfile returns the profile registered with the name.
+func LookupProfile(name string) (*Profile, error) {
+	profiles.Lock()
+	defer profiles.Unlock()
+	p, ok := profiles.m[name]
+	if !ok {
+		return nil, fmt.Errorf("d2xx: unknown profile %q", name)
+	}
+	return p, nil
+}
@@ -0,0 +1,122 @@
+package d2xx
+
+import (
+	"fmt"
+
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// BoardConfig is the EEPROM content of a cartridge dumper board, programmed
+// once during its assembly.
+type BoardConfig struct {
+	Manufacturer string
+	// ManufacturerID is the prefix of the serial numbers, e.g. "FT".
+	ManufacturerID string
+	// Description is the USB product string; the driver appends " A" and " B"
+	// for the channels.
+	Description string
+	// Serial is the chip serial number; the driver appends "A" and "B" for the
+	// channels.
+	Serial string
+	// MaxPowerMA is the current drawn from the bus, up to 500mA.
+	MaxPowerMA   uint16
+	SelfPowered  bool
+	RemoteWakeup bool
+	// Identity, if not nil, is written to the user area.
+	Identity *Identity
+}
+
+func (c *BoardConfig) validate() error {
+	if c.Serial == "" {
+		return fmt.Errorf("d2xx: board config has no serial number")
+	}
+	if c.MaxPowerMA == 0 || c.MaxPowerMA > 500 {
+		return fmt.Errorf("d2xx: max power must be between 1 and 500mA, not %dmA", c.MaxPowerMA)
+	}
+	return nil
+}
+
+// ProgramBoard programs the EEPROM of a FT2232H, blank or not: the strings,
+// the power settings, both channels for D2XX and MPSSE, then the identity.
+// The drive settings are kept, except 0 which is set to the default 4mA.
+//
+// The new settings are only used once the device enumerates again, see
+// Reenumerate.
+func (d *Device) ProgramBoard(c *BoardConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	var ee ftdi.EEPROM
+	e, err := d.ft2232hEEPROM(&ee)
+	if err != nil {
+		return err
+	}
+	ee.Manufacturer = c.Manufacturer
+	ee.ManufacturerID = c.ManufacturerID
+	ee.Desc = c.Description
+	ee.Serial = c.Serial
+	e.SerNumEnable = 1
+	e.MaxPower = c.MaxPowerMA
+	e.SelfPowered = boolByte(c.SelfPowered)
+	e.RemoteWakeup = boolByte(c.RemoteWakeup)
+	for _, f := range channelFields(e) {
+		*f.v = 0
+	}
+	writeDrive(e, readDrive(e))
+	if err := d.programEEPROM(&ee); err != nil {
+		return err
+	}
+	if c.Identity != nil {
+		// the user area starts after the strings just programmed
+		return d.WriteIdentity(c.Identity)
+	}
+	return nil
+}
+
+// VerifyBoard reads the EEPROM of a FT2232H back and returns an error
+// describing the first setting differing from c.
+func (d *Device) VerifyBoard(c *BoardConfig) error {
+	var ee ftdi.EEPROM
+	e, err := d.ft2232hEEPROM(&ee)
+	if err != nil {
+		return err
+	}
+	for _, s := range []struct{ name, got, want string }{
+		{"manufacturer", ee.Manufacturer, c.Manufacturer},
+		{"manufacturer ID", ee.ManufacturerID, c.ManufacturerID},
+		{"description", ee.Desc, c.Description},
+		{"serial", ee.Serial, c.Serial},
+	} {
+		if s.got != s.want {
+			return fmt.Errorf("d2xx: EEPROM %s is %q instead of %q", s.name, s.got, s.want)
+		}
+	}
+	if e.SerNumEnable == 0 {
+		return fmt.Errorf("d2xx: EEPROM serial number is disabled")
+	}
+	if e.MaxPower != c.MaxPowerMA || (e.SelfPowered != 0) != c.SelfPowered || (e.RemoteWakeup != 0) != c.RemoteWakeup {
+		return fmt.Errorf("d2xx: EEPROM power settings are %dmA, self powered %t, remote wakeup %t", e.MaxPower, e.SelfPowered != 0, e.RemoteWakeup != 0)
+	}
+	if issues := checkChannels(e); len(issues) != 0 {
+		return fmt.Errorf("d2xx: EEPROM %s", issues[0])
+	}
+	if c.Identity != nil {
+		id, err := d.readIdentity()
+		if err != nil {
+			return err
+		}
+		if *id != *c.Identity {
+			return fmt.Errorf("d2xx: identity is %s instead of %s", id, c.Identity)
+		}
+	}
+	return nil
+}
+
+// Reenumerate makes the device enumerate again, so that the EEPROM content is
+// used, and closes it. The driver doesn't support it on all platforms; the
+// device then has to be replugged.
+func (d *Device) Reenumerate() error {
+	e := d.h.d2xxCyclePort()
+	d.Close()
+	return toErr("CyclePort", e)
+}
@@ -0,0 +1,156 @@
+package d2xx
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// future is the pending result of a batch of commands submitted to a device.
+type future struct {
+	// written is closed once the commands are written, successfully or not.
+	written chan struct{}
+	// done is closed once the response is read.
+	done chan struct{}
+	resp []byte
+	err  error
+}
+
+// wait blocks until the batch is processed and returns its response.
+func (f *future) wait() ([]byte, error) {
+	<-f.done
+	return f.resp, f.err
+}
+
+// batch is a batch of commands queued to a device.
+type batch struct {
+	cmd   []byte
+	n     int
+	after <-chan struct{}
+	f     *future
+	// epoch is the number of resynchronizations before it was written.
+	epoch int
+}
+
+var errClosed = errors.New("d2xx: device is closed")
+
+// submit queues a batch of MPSSE commands whose response is n bytes long.
+// Each batch is checked for bad commands, see readMpsse.
+//
+// The batches of a device are written, and their responses read, in
+// submission order by the goroutines owning the device I/O. A batch is
+// written while the response to the previous one is read. cmd must not be
+// modified until the future is done. submit is safe for concurrent use.
+func (d *device) submit(cmd []byte, n int) *future {
+	return d.submitAfter(cmd, n, nil)
+}
+
+// submitAfter is like submit but waits for after to be closed before writing
+// the commands. It orders writes across devices; a channel waiting on a pin
+// driven by another channel must be written first.
+func (d *device) submitAfter(cmd []byte, n int, after <-chan struct{}) *future {
+	f := &future{written: make(chan struct{}), done: make(chan struct{})}
+	d.queueMu.Lock()
+	defer d.queueMu.Unlock()
+	if d.queueClosed {
+		f.err = errClosed
+		close(f.written)
+		close(f.done)
+		return f
+	}
+	if d.queue == nil {
+		d.queue = make(chan batch, 16)
+		d.queueDone = make(chan struct{})
+		go d.serve(d.queue, d.queueDone)
+	}
+	d.queue <- batch{cmd: cmd, n: n, after: after, f: f}
+	return f
+}
+
+// transfer submits a batch of commands and waits for its response.
+func (d *device) transfer(cmd []byte, n int) ([]byte, error) {
+	return d.submit(cmd, n).wait()
+}
+
+// send submits a batch of commands without response and waits for it.
+func (d *device) send(cmd []byte) error {
+	_, err := d.transfer(cmd, 0)
+	return err
+}
+
+// maxInFlight is how many batches may be written ahead of the one whose
+// response is read.
+const maxInFlight = 16
+
+// serve is the goroutine owning the device I/O. It writes the batches and
+// hands them over in order to a reader goroutine, so that the next batch is
+// written while the response to the previous one is read.
+//
+// Once a batch fails, the writes stop until the reader resynchronizes the
+// engine. The batches written in between are discarded and fail as well.
+func (d *device) serve(queue <-chan batch, done chan<- struct{}) {
+	defer close(done)
+	inFlight := make(chan batch, maxInFlight)
+	read := make(chan struct{})
+	// mu is held while writing; epoch is only changed by the reader, under mu.
+	var mu sync.Mutex
+	epoch := 0
+	go func() {
+		defer close(read)
+		for b := range inFlight {
+			switch {
+			case b.epoch != epoch:
+				b.f.err = fmt.Errorf("d2xx: channel %s: batch discarded after a failed one", d.channel)
+			case b.f.err == nil:
+				b.f.resp = make([]byte, b.n)
+				b.f.err = d.readMpsse(b.f.resp)
+			}
+			if b.epoch == epoch && b.f.err != nil {
+				mu.Lock()
+				epoch++
+				if err := d.syncMpsse(); err != nil {
+					b.f.err = fmt.Errorf("%w; %v", b.f.err, err)
+				}
+				mu.Unlock()
+			}
+			close(b.f.done)
+		}
+	}()
+	for b := range queue {
+		if b.after != nil {
+			<-b.after
+		}
+		mu.Lock()
+		b.epoch = epoch
+		b.f.err = d.writeAll(withMarker(b.cmd))
+		mu.Unlock()
+		close(b.f.written)
+		inFlight <- b
+	}
+	close(inFlight)
+	<-read
+}
+
+// stopQueue processes the batches already queued and stops the goroutine
+// owning the device I/O. Later submissions fail.
+func (d *device) stopQueue() {
+	d.queueMu.Lock()
+	queue, done := d.queue, d.queueDone
+	d.queue = nil
+	d.queueClosed = true
+	d.queueMu.Unlock()
+	if queue != nil {
+		close(queue)
+		<-done
+	}
+}
+
+// drainQueue waits for the batches already queued and stops the goroutine
+// owning the device I/O, which is started again by the next submission. The
+// caller must not submit anything meanwhile.
+func (d *device) drainQueue() {
+	d.stopQueue()
+	d.queueMu.Lock()
+	d.queueClosed = false
+	d.queueMu.Unlock()
+}
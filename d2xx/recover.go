@@ -0,0 +1,188 @@
+package d2xx
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RecoveryStep is a step of the recovery of an unresponsive MPSSE channel,
+// from the least to the most disruptive.
+type RecoveryStep uint8
+
+const (
+	// RecoverPurge discards the driver buffers.
+	RecoverPurge RecoveryStep = iota
+	// RecoverResync discards any stale response until the MPSSE echoes a bogus
+	// command.
+	RecoverResync
+	// RecoverReset resets the device and sets it up again.
+	RecoverReset
+	// RecoverResetPort resets the USB port and sets the device up again.
+	RecoverResetPort
+	// RecoverReopen makes the device re-enumerate, then closes it and opens it
+	// again by serial number, or by location.
+	RecoverReopen
+)
+
+func (s RecoveryStep) String() string {
+	switch s {
+	case RecoverPurge:
+		return "purge"
+	case RecoverResync:
+		return "resync"
+	case RecoverReset:
+		return "reset"
+	case RecoverResetPort:
+		return "reset port"
+	case RecoverReopen:
+		return "reopen"
+	default:
+		return fmt.Sprintf("RecoveryStep(%d)", s)
+	}
+}
+
+// reopenTimeout is how long the device has to enumerate again.
+const reopenTimeout = 5 * time.Second
+
+// recoverMpsse brings back an unresponsive MPSSE channel. It escalates through
+// the steps until the MPSSE echoes a bogus command again, and returns the step
+// which succeeded.
+//
+// setup restores the state lost by a reset, like the clock and the pins. The
+// queued batches are waited for first; the caller must not submit anything
+// until it returns.
+func (d *device) recoverMpsse(setup func() error) (RecoveryStep, error) {
+	d.drainQueue()
+	var err error
+	for s := RecoverPurge; s <= RecoverReopen; s++ {
+		if err = d.recoverStep(s, setup); err == nil {
+			if err = d.pingMpsse(); err == nil {
+				return s, nil
+			}
+		}
+	}
+	return RecoverReopen, fmt.Errorf("d2xx: channel %s: recovery failed: %w", d.channel, err)
+}
+
+func (d *device) recoverStep(s RecoveryStep, setup func() error) error {
+	switch s {
+	case RecoverPurge:
+		return d.purge()
+	case RecoverResync:
+		return d.syncMpsse()
+	case RecoverReset:
+		if err := d.reset(); err != nil {
+			return err
+		}
+	case RecoverResetPort:
+		if e := d.h.d2xxResetPort(); e != 0 {
+			return toErr("ResetPort", e)
+		}
+	case RecoverReopen:
+		if err := d.reopen(); err != nil {
+			return err
+		}
+	}
+	if err := d.setupCommon(); err != nil {
+		return err
+	}
+	if err := d.setBitMode(0, BitModeMpsse); err != nil {
+		return err
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := d.tryMpsse(); err != nil {
+		return err
+	}
+	return setup()
+}
+
+// reopen makes the device re-enumerate and replaces its handle with a new one
+// opened by serial number. A blank chip, or one whose serial number isn't
+// found, is opened by location instead.
+//
+// If it fails, the device is left closed.
+func (d *device) reopen() error {
+	// The port may not support cycling; reopening is still worth a try.
+	_ = d.h.d2xxCyclePort()
+	_ = d.h.d2xxClose()
+	// the old handle must not be used, nor closed again
+	d.queueMu.Lock()
+	d.h = handle(0)
+	d.closed = true
+	d.queueClosed = true
+	d.queueMu.Unlock()
+	loc := strconv.FormatUint(uint64(d.locID), 10)
+	deadline := time.Now().Add(reopenTimeout)
+	var h d2xxHandle
+	for {
+		time.Sleep(100 * time.Millisecond)
+		e := 2 // FT_DEVICE_NOT_FOUND
+		if d.serial != "" {
+			h, e = d2xxOpenEx(d.serial, openBySerial)
+		}
+		if e != 0 {
+			h, e = d2xxOpenEx(loc, openByLocation)
+		}
+		if e == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return toErr("Open", e)
+		}
+	}
+	if err := d.checkReopened(h); err != nil {
+		_ = h.d2xxClose()
+		return err
+	}
+	d.queueMu.Lock()
+	d.h = h
+	d.closed = false
+	d.queueClosed = false
+	d.queueMu.Unlock()
+	return d.reset()
+}
+
+// checkReopened checks that h is the same device, and not another board
+// plugged in the same port.
+func (d *device) checkReopened(h d2xxHandle) error {
+	t, _, _, e := h.d2xxGetDeviceInfo()
+	if e != 0 {
+		return toErr("GetDeviceInfo", e)
+	}
+	if t != d.t {
+		return fmt.Errorf("d2xx: channel %s reopened as %s instead of %s", d.channel, t, d.t)
+	}
+	serial, e := h.d2xxGetSerialNumber()
+	if e != 0 {
+		return toErr("GetSerialNumber", e)
+	}
+	locID, e := h.d2xxGetLocID()
+	if e != 0 {
+		return toErr("GetLocID", e)
+	}
+	if serial != d.serial || locID != d.locID {
+		return fmt.Errorf("d2xx: channel %s reopened as %q at location 0x%x instead of %q at 0x%x", d.channel, serial, locID, d.serial, d.locID)
+	}
+	return nil
+}
+
+// pingMpsse checks that the MPSSE echoes a bogus command and nothing else.
+func (d *device) pingMpsse() error {
+	if err := d.writeAll([]byte{0xab}); err != nil {
+		return err
+	}
+	var b [2]byte
+	if err := d.readAll(b[:]); err != nil {
+		return err
+	}
+	if b[0] != 0xfa || b[1] != 0xab {
+		return fmt.Errorf("d2xx: channel %s: MPSSE answered 0x%02x 0x%02x to a bogus command", d.channel, b[0], b[1])
+	}
+	var extra [64]byte
+	n, err := d.read(extra[:])
+	if err == nil && n != 0 {
+		err = fmt.Errorf("d2xx: channel %s: %d unexpected bytes after the bogus command echo", d.channel, n)
+	}
+	return err
+}
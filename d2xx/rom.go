@@ -1,60 +1,178 @@
 package d2xx
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/ysh86/ft64/d2xx/ftdi"
 )
 
+// rom is the cartridge dumper.
+//
+// It is safe for concurrent use.
 type rom struct {
-	devA     *device
-	devB     *device
-	commands [8192 * 2]byte
+	devA *Device
+	devB *Device
+	// mu orders the submissions to both channels, since channel B waits for
+	// the pins driven by channel A.
+	mu    sync.Mutex
+	clock Clock
+	// addrWait is the ALE setup delay, see Identity.
+	addrWait uint16
+	profile  *Profile
+	identity *Identity
+	issues   []ChannelIssue
+	// gen counts the recoveries, so reads failed before the last one are
+	// retried without recovering again.
+	gen        int
+	onRecovery func(channel string, s RecoveryStep)
 }
 
-func OpenROM() (*rom, error) {
-	const (
-		SUPPORTED = ftdi.FT2232H
-	)
+// maxRetries is how many times a failed read is retried after recovering the
+// channels.
+const maxRetries = 3
+
+// n64Clock is the default clock: master 60_000_000 / ((1+0x0002)*3) [Hz] =
+// 6.67[MHz], three-phase clocking makes each bit last 150[ns].
+var n64Clock = Clock{Divisor: 2, ThreePhase: true}
+
+// n64AddressWait is the default ALE setup delay: 9 bytes last 12.5[us].
+const n64AddressWait = 9
+
+// n64Identity reads the board identity, if any, and returns it with the
+// timing it calibrates.
+//
+// The user area of a blank EEPROM can't be read, which just means there is no
+// identity. A corrupted identity is an error.
+func n64Identity(d *device) (*Identity, Clock, uint16, error) {
+	clock, addrWait := n64Clock, uint16(n64AddressWait)
+	ua, err := d.readUA()
+	if err != nil {
+		return nil, clock, addrWait, nil
+	}
+	id := &Identity{}
+	if err := id.UnmarshalBinary(ua); err != nil {
+		if errors.Is(err, ErrNoIdentity) {
+			return nil, clock, addrWait, nil
+		}
+		return nil, clock, addrWait, err
+	}
+	if id.ClockHz != 0 {
+		if clock, err = NewClock(int64(id.ClockHz), false, n64Clock.ThreePhase); err != nil {
+			return nil, clock, addrWait, err
+		}
+	}
+	if id.AddressWait != 0 {
+		addrWait = id.AddressWait
+	}
+	return id, clock, addrWait, nil
+}
+
+// OpenROM opens the first cartridge dumper found. opts can be nil.
+func OpenROM(opts *Options) (*rom, error) {
+	return openROM(nil, nil, opts)
+}
+
+// OpenROMBySerial opens the cartridge dumper whose FT2232H has the serial
+// number, without the channel suffix, e.g. "FT1234". opts can be nil.
+func OpenROMBySerial(serial string, opts *Options) (*rom, error) {
+	return openROM(func(a *DeviceInfo) bool {
+		s, _ := chipSerial(a.Serial)
+		return s == serial
+	}, nil, opts)
+}
 
-	// open 1st & 2nd devs
-	num, err := numDevices()
-	if err != nil || num < 2 {
-		return nil, fmt.Errorf("numDevices: num=%d, err=%w", num, err)
+// OpenROMByLocation opens the cartridge dumper whose channel A has the USB
+// location ID loc. opts can be nil.
+func OpenROMByLocation(loc uint32, opts *Options) (*rom, error) {
+	return openROM(func(a *DeviceInfo) bool {
+		return a.LocID == loc
+	}, nil, opts)
+}
+
+// OpenROMProfile opens the first cartridge dumper found, wired as described by
+// p. opts can be nil.
+func OpenROMProfile(p *Profile, opts *Options) (*rom, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
 	}
-	devA, err := openDev(d2xxOpen, 0)
+	return openROM(nil, p, opts)
+}
+
+// openROM opens the channels A and B of the first FT2232H accepted by match.
+// A nil p selects the profile of the board identity, or the default one.
+func openROM(match func(a *DeviceInfo) bool, p *Profile, opts *Options) (*rom, error) {
+	// find both channels of the chip
+	devs, err := ListDevices()
 	if err != nil {
 		return nil, err
 	}
-	if devA.t != SUPPORTED {
-		devA.closeDev()
-		return nil, fmt.Errorf("device is not %s, but %s", SUPPORTED, devA.t)
+	infoA, infoB, err := findPair(devs, match)
+	if err != nil {
+		return nil, err
+	}
+	devA, err := openDev(d2xxOpen, infoA.Index, opts)
+	if err != nil {
+		if devA != nil {
+			devA.closeDev()
+		}
+		return nil, err
 	}
-	devB, err := openDev(d2xxOpen, 1)
+	devB, err := openDev(d2xxOpen, infoB.Index, opts)
 	if err != nil {
 		devA.closeDev()
+		if devB != nil {
+			devB.closeDev()
+		}
 		return nil, err
 	}
-	if devB.t != SUPPORTED {
+	// blank chips have no serial number, the location tells them apart
+	if devA.serial != infoA.Serial || devB.serial != infoB.Serial || devA.locID != infoA.LocID || devB.locID != infoB.LocID {
 		devA.closeDev()
 		devB.closeDev()
-		return nil, fmt.Errorf("device is not %s, but %s", SUPPORTED, devB.t)
+		return nil, errors.New("d2xx: the devices changed while being opened")
 	}
 
-	// configure devices for MPSSE
-	err = devA.reset()
-	if err != nil {
-		devA.closeDev()
-		devB.closeDev()
-		return nil, err
+	// the board identity selects the profile and the timing
+	id, clock, addrWait, err := n64Identity(devA)
+	if err == nil && p == nil {
+		name := DefaultProfile
+		if id != nil {
+			name = id.Profile
+		}
+		p, err = LookupProfile(name)
 	}
-	err = devB.reset()
 	if err != nil {
 		devA.closeDev()
 		devB.closeDev()
 		return nil, err
 	}
+
+	// a channel set up for VCP or FIFO in the EEPROM misbehaves on some hosts;
+	// the dumper still tries, the caller decides whether to repair
+	var issues []ChannelIssue
+	var ee ftdi.EEPROM
+	if v, err := devA.ft2232hEEPROM(&ee); err == nil {
+		issues = checkChannels(v)
+	}
+
+	// configure devices for MPSSE
+	if devA.opts.Reset {
+		err = devA.reset()
+		if err != nil {
+			devA.closeDev()
+			devB.closeDev()
+			return nil, err
+		}
+		err = devB.reset()
+		if err != nil {
+			devA.closeDev()
+			devB.closeDev()
+			return nil, err
+		}
+	}
 	err = devA.setupCommon()
 	if err != nil {
 		devA.closeDev()
@@ -67,29 +185,38 @@ func OpenROM() (*rom, error) {
 		devB.closeDev()
 		return nil, err
 	}
-	err = devA.setBitMode(0, bitModeMpsse)
+	err = devA.setBitMode(0, BitModeMpsse)
 	if err != nil {
 		devA.closeDev()
 		devB.closeDev()
 		return nil, err
 	}
-	err = devB.setBitMode(0, bitModeMpsse)
+	err = devB.setBitMode(0, BitModeMpsse)
 	if err != nil {
 		devA.closeDev()
 		devB.closeDev()
 		return nil, err
 	}
 
-	r := &rom{devA: devA, devB: devB}
+	devA.channel = "A"
+	devB.channel = "B"
+	r := &rom{devA: &Device{devA}, devB: &Device{devB}, clock: clock, addrWait: addrWait, profile: p, identity: id, issues: issues}
 	time.Sleep(50 * time.Millisecond)
 
 	// try MPSSE
-	err = r.tryMpsse(r.devA)
+	err = r.devA.tryMpsse()
 	if err != nil {
 		r.Close()
 		return nil, err
 	}
-	err = r.tryMpsse(r.devB)
+	err = r.devB.tryMpsse()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	// check which channel is wired as A
+	err = r.n64ProbeRoles()
 	if err != nil {
 		r.Close()
 		return nil, err
@@ -113,92 +240,195 @@ func OpenROM() (*rom, error) {
 }
 
 func (r *rom) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// the channel B waits on pins driven by the channel A, so both queues are
+	// drained before either mode is reset
+	for _, d := range []*Device{r.devA, r.devB} {
+		if d != nil {
+			d.stopQueue()
+		}
+	}
 	if r.devA != nil {
-		r.devA.setBitMode(0, bitModeReset)
-		r.devA.closeDev()
+		r.devA.resetAndClose()
 		r.devA = nil
 	}
 	if r.devB != nil {
-		r.devB.setBitMode(0, bitModeReset)
-		r.devB.closeDev()
+		r.devB.resetAndClose()
 		r.devB = nil
 	}
 }
 
-func (r *rom) DevInfo() (ftdi.DevType, uint16, uint16) {
-	return r.devB.t, r.devB.venID, r.devB.devID
+// Identity returns the board identity read at open, or nil if the board has
+// none.
+func (r *rom) Identity() *Identity {
+	return r.identity
 }
 
-func (r *rom) Read512(addr uint32) ([]byte, error) {
-	err := r.n64SetAddress(addr)
-	if err != nil {
-		return nil, err
-	}
-
-	data, err := r.n64ReadROM512()
-	if err != nil {
-		return nil, err
-	}
-	return data, nil
+// ChannelIssues returns the EEPROM settings found at open which keep the
+// channels from being used reliably, see Device.RepairChannels.
+func (r *rom) ChannelIssues() []ChannelIssue {
+	return r.issues
 }
 
-func (r *rom) tryMpsse(dev *device) error {
-	b := 0
-	e := 0
+// Profile returns the wiring profile in use.
+func (r *rom) Profile() *Profile {
+	return r.profile
+}
 
-	// Enable loopback
-	r.commands[e] = 0x84
-	e++
-	sent, err := dev.write(r.commands[b:e])
-	if err != nil {
-		return err
-	}
-	if sent != e-b {
-		return fmt.Errorf("failed to write command: 0x%02x", r.commands[b])
-	}
-	b++
-	// Check the receive buffer is empty
-	n, err := dev.read(r.commands[e : e+1])
-	if n != 0 || err != nil {
-		return fmt.Errorf("MPSSE receive buffer should be empty: n=%d, err=%w", n, err)
+// DevInfo returns the device type, the vendor ID and the device ID, or an
+// error once closed.
+func (r *rom) DevInfo() (ftdi.DevType, uint16, uint16, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.devA == nil {
+		return ftdi.Unknown, 0, 0, errClosed
 	}
+	return r.devA.t, r.devA.venID, r.devA.devID, nil
+}
 
-	// Synchronize the MPSSE
-	r.commands[e] = 0xab // bogus command
-	e++
-	_, err = dev.write(r.commands[b:e])
-	b++
-	for n == 0 && err == nil {
-		n, err = dev.read(r.commands[e : e+2])
-	}
+// SetClock changes the clock of both channels to the highest frequency not
+// above hz and returns it.
+//
+// It permits to slow down the bus for the slower cartridges.
+func (r *rom) SetClock(hz int64) (int64, error) {
+	r.mu.Lock()
+	if r.devA == nil {
+		r.mu.Unlock()
+		return 0, errClosed
+	}
+	c, err := NewClock(hz, false, r.clock.ThreePhase)
 	if err != nil {
-		return err
-	}
-	if n != 2 || r.commands[e] != 0xfa || r.commands[e+1] != 0xab {
-		return fmt.Errorf("failed to synchronize the MPSSE")
+		r.mu.Unlock()
+		return 0, err
 	}
+	cmd := c.appendCommands(nil)
+	fA := r.devA.submit(cmd, 0)
+	fB := r.devB.submit(cmd, 0)
+	r.clock = c
+	r.mu.Unlock()
 
-	// Disable loopback
-	r.commands[e] = 0x85
-	e++
-	sent, err = dev.write(r.commands[b:e])
-	if err != nil {
-		return err
-	}
-	if sent != e-b {
-		return fmt.Errorf("failed to write command: 0x%02x", r.commands[b])
+	if _, err := fA.wait(); err != nil {
+		return 0, err
 	}
-	b++
-	// Check the receive buffer is empty
-	n, err = dev.read(r.commands[e : e+1])
-	if n != 0 || err != nil {
-		return fmt.Errorf("MPSSE receive buffer should be empty: n=%d, err=%w", n, err)
+	if _, err := fB.wait(); err != nil {
+		return 0, err
 	}
+	return c.Hz(), nil
+}
+
+// OnRecovery sets f to be called with the step which brought back a channel
+// after a failed read.
+func (r *rom) OnRecovery(f func(channel string, s RecoveryStep)) {
+	r.mu.Lock()
+	r.onRecovery = f
+	r.mu.Unlock()
+}
+
+func (r *rom) Read512(addr uint32) ([]byte, error) {
+	return r.Read512Async(addr)()
+}
 
+// recover brings back both channels after a read issued at generation gen
+// failed. It does nothing if they were recovered since.
+func (r *rom) recover(gen int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.devA == nil {
+		return errClosed
+	}
+	if gen != r.gen {
+		return nil
+	}
+	r.gen++
+	// Drain both queues first, channel A may be waiting on channel B.
+	r.devA.drainQueue()
+	r.devB.drainQueue()
+	for _, c := range []struct {
+		dev   *Device
+		setup func() error
+	}{
+		{r.devB, r.n64SetupPinsB},
+		{r.devA, r.n64SetupPinsA},
+	} {
+		s, err := c.dev.recoverMpsse(c.setup)
+		if err != nil {
+			return err
+		}
+		if r.onRecovery != nil {
+			r.onRecovery(c.dev.channel, s)
+		}
+	}
 	return nil
 }
 
-// n64 pins:
+// Read512Async queues the read of 512 bytes at addr and returns a function
+// waiting for them.
+//
+// It permits to prepare and queue the next reads while the previous ones are
+// in flight.
+//
+// A failed read recovers the channels and is retried up to maxRetries times.
+func (r *rom) Read512Async(addr uint32) func() ([]byte, error) {
+	c := newN64Cmds(r.profile, make([]byte, 0, 8192), make([]byte, 0, 2048))
+	c.addrWait = r.addrWait
+	c.setAddress(addr)
+	c.readROM512()
+	a, b := c.cmd[0], c.cmd[1]
+
+	submit := func() (fA, fB *future, gen int, err error) {
+		// Channel B must wait before channel A drives the pins.
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.devA == nil {
+			return nil, nil, 0, errClosed
+		}
+		fB = r.devB.submit(b, 256)
+		fA = r.devA.submitAfter(a, 256, fB.written)
+		return fA, fB, r.gen, nil
+	}
+	fA, fB, gen, err := submit()
+
+	return func() ([]byte, error) {
+		var resp [2][]byte
+		for retry := 0; ; retry++ {
+			if err != nil {
+				return nil, err
+			}
+			var errA, errB error
+			resp[1], errB = fB.wait()
+			resp[0], errA = fA.wait()
+			if errB == nil && errA == nil {
+				break
+			}
+			if errB == nil {
+				errB = errA
+			}
+			if retry == maxRetries {
+				return nil, errB
+			}
+			if err = r.recover(gen); err != nil {
+				return nil, fmt.Errorf("%w; %v", errB, err)
+			}
+			fA, fB, gen, err = submit()
+		}
+
+		// interleave AD15-8 and AD7-0
+		hi := resp[r.profile.ADHigh.signal().role()]
+		lo := resp[r.profile.ADLow.signal().role()]
+		result := make([]byte, 512)
+		for i := 0; i < 256; i++ {
+			result[i*2+0] = hi[i]
+			result[i*2+1] = lo[i]
+		}
+		return result, nil
+	}
+}
+
+// n64 pins, as described by the default profile in profiles/n64.json:
+//
+// The channels A and B below are the roles; n64ProbeRoles finds which channel
+// of the chip is wired for each.
 //
 // Channel A:
 // ADBUS0: TCK/SK: OUT (SPI SCLK)
@@ -210,14 +440,7 @@ func (r *rom) tryMpsse(dev *device) error {
 // ADBUS6: GPIOL2: OUT ALE_L
 // ADBUS7: GPIOL3: OUT ALE_H
 //
-// ACBUS0: GPIOH0: I/O AD0 (default: In)
-// ACBUS1: GPIOH1: I/O AD1 (default: In)
-// ACBUS2: GPIOH2: I/O AD2 (default: In)
-// ACBUS3: GPIOH3: I/O AD3 (default: In)
-// ACBUS4: GPIOH4: I/O AD4 (default: In)
-// ACBUS5: GPIOH5: I/O AD5 (default: In)
-// ACBUS6: GPIOH6: I/O AD6 (default: In)
-// ACBUS7: GPIOH7: I/O AD7 (default: In)
+// ACBUS0-7: GPIOH0-7: I/O AD0-7 (default: In)
 //
 // Channel B:
 // BDBUS0: TCK/SK: OUT (SPI SCLK)
@@ -229,108 +452,125 @@ func (r *rom) tryMpsse(dev *device) error {
 // BDBUS6: GPIOL2: OUT CLK
 // BDBUS7: GPIOL3: IN  S_DAT // TODO: Not used. It should be output/Lo? or Pull-up.
 //
-// BCBUS0: GPIOH0: I/O AD8  (default: In)
-// BCBUS1: GPIOH1: I/O AD9  (default: In)
-// BCBUS2: GPIOH2: I/O AD10 (default: In)
-// BCBUS3: GPIOH3: I/O AD11 (default: In)
-// BCBUS4: GPIOH4: I/O AD12 (default: In)
-// BCBUS5: GPIOH5: I/O AD13 (default: In)
-// BCBUS6: GPIOH6: I/O AD14 (default: In)
-// BCBUS7: GPIOH7: I/O AD15 (default: In)
+// BCBUS0-7: GPIOH0-7: I/O AD8-15 (default: In)
 func (r *rom) n64SetupPins() error {
-	b := 0
-	e := 0
-
-	// clock: master 60_000_000 / ((1+0x0002)*2) [Hz] = 10[MHz]
-	// TODO: 7.5[MHz] for flash:3
-	clockDivisorHi := uint8(0x00)
-	clockDivisorLo := uint8(0x02)
-	r.commands[e] = 0x8a // Use 60MHz master clock
-	e++
-	r.commands[e] = 0x97 // Turn off adaptive clocking
-	e++
-	r.commands[e] = 0x8c // Enable three-phase clocking for I2C EEPROM
-	e++
-	r.commands[e] = 0x86 // set clock divisor
-	e++
-	r.commands[e] = clockDivisorLo
-	e++
-	r.commands[e] = clockDivisorHi
-	e++
-	_, err := r.devA.write(r.commands[b:e])
-	if err != nil {
+	if err := r.n64SetupPinsA(); err != nil {
 		return err
 	}
-	_, err = r.devB.write(r.commands[b:e])
-	if err != nil {
+	return r.n64SetupPinsB()
+}
+
+func (r *rom) n64SetupPinsA() error {
+	return r.n64SetupChannel(0)
+}
+
+func (r *rom) n64SetupPinsB() error {
+	return r.n64SetupChannel(1)
+}
+
+// n64SetupChannel sets the clock and the idle state of the pins of the
+// channel role.
+func (r *rom) n64SetupChannel(role int) error {
+	dev := r.devA
+	if role == 1 {
+		dev = r.devB
+	}
+	// clock: see SetClock to change it at runtime
+	if err := dev.setClock(r.clock); err != nil {
 		return err
 	}
-	b = e
-
-	// pins A
-	r.commands[e] = 0x80
-	e++
-	r.commands[e] = 0b1011_0001 // ALE_H:1, ALE_L:0, /RE:1, /WE:1, CS:0, (MISO:0, MOSI:0, SCLK:1)
-	e++
-	r.commands[e] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out, (MISO:In, MOSI:Out, SCLK:Out)
-	e++
-	r.commands[e] = 0x82
-	e++
-	r.commands[e] = 0x00 // AD7-0:0
-	e++
-	r.commands[e] = 0x00 // AD7-0:In
-	e++
-	_, err = r.devA.write(r.commands[b:e])
-	if err != nil {
+	c := newN64Cmds(r.profile, nil, nil)
+	c.writeBus(role, 0)
+	c.writeBus(role, 1)
+	return dev.send(c.cmd[role])
+}
+
+// n64ProbeRoles checks that devA is the channel driving CS, which is wired
+// to WAIT (GPIOL1) of devB, and swaps the channels if it is the other way
+// around.
+//
+// The enumeration order doesn't guarantee that the first channel is wired as
+// A.
+func (r *rom) n64ProbeRoles() error {
+	ok, err := n64Drives(r.profile, r.devA, r.devB)
+	if err != nil || ok {
 		return err
 	}
-	b = e
-
-	// pins B
-	r.commands[e] = 0x80
-	e++
-	r.commands[e] = 0b0101_0001 // S_DAT:0, CLK:1, WAIT:0, /RST:1, CS:0, (MISO:0, MOSI:0, SCLK:1)
-	e++
-	r.commands[e] = 0b0101_1011 // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out, (MISO:In, MOSI:Out, SCLK:Out)
-	e++
-	r.commands[e] = 0x82
-	e++
-	r.commands[e] = 0x00 // AD15-8:0
-	e++
-	r.commands[e] = 0x00 // AD15-8:In
-	e++
-	_, err = r.devB.write(r.commands[b:e])
+	ok, err = n64Drives(r.profile, r.devB, r.devA)
 	if err != nil {
 		return err
 	}
-
+	if !ok {
+		return fmt.Errorf("d2xx: CS of neither channel %s nor %s reaches GPIOL1 of the other; check the board wiring", r.devA.channel, r.devB.channel)
+	}
+	r.devA, r.devB = r.devB, r.devA
 	return nil
 }
 
+// n64Drives returns true if out toggling its CS is seen on the WAIT of in. All
+// the other pins are inputs meanwhile.
+func n64Drives(p *Profile, out, in *Device) (bool, error) {
+	cs, wait := p.CS, p.WAIT
+	cmd := make([]byte, 0, 16)
+	cmd = append(cmd,
+		0x80|byte(wait.bus())<<1,
+		0x00, // all:0
+		0x00, // all:In
+	)
+	if err := in.send(cmd); err != nil {
+		return false, err
+	}
+	ok := true
+	for _, level := range []byte{0, 1, 0} {
+		cmd = append(cmd[:0],
+			0x80|byte(cs.bus())<<1,
+			level<<cs.Bit, // CS:level
+			cs.mask(),     // CS:Out
+		)
+		if err := out.send(cmd); err != nil {
+			return false, err
+		}
+		cmd = append(cmd[:0],
+			0x81|byte(wait.bus())<<1, // WAIT
+			0x87,
+		)
+		b, err := in.transfer(cmd, 1)
+		if err != nil {
+			return false, err
+		}
+		if b[0]>>wait.Bit&1 != level {
+			ok = false
+			break
+		}
+	}
+	// release CS
+	cmd = append(cmd[:0],
+		0x80|byte(cs.bus())<<1,
+		0x00, // all:0
+		0x00, // all:In
+	)
+	if err := out.send(cmd); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
 func (r *rom) n64ResetCart() error {
-	b := 0
-	e := 0
-
-	// pins B
-	r.commands[e] = 0x80
-	e++
-	r.commands[e] = 0b0100_0001 // S_DAT, CLK, WAIT, /RST:0, CS
-	e++
-	r.commands[e] = 0b0101_1011 // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
-	e++
-	_, err := r.devB.write(r.commands[b:e])
-	if err != nil {
+	p := r.profile
+	role := p.RST.role()
+	dev := r.devA
+	if role == 1 {
+		dev = r.devB
+	}
+
+	c := newN64Cmds(p, nil, nil)
+	c.drive(level{p.RST, !p.RST.Idle})
+	if err := dev.send(c.cmd[role]); err != nil {
 		return err
 	}
-	b = e
-	r.commands[e] = 0x80
-	e++
-	r.commands[e] = 0b0101_0001 // S_DAT, CLK, WAIT, /RST:1, CS
-	e++
-	r.commands[e] = 0b0101_1011 // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
-	e++
-	_, err = r.devB.write(r.commands[b:e])
-	if err != nil {
+	c.cmd[role] = c.cmd[role][:0]
+	c.drive(level{p.RST, p.RST.Idle})
+	if err := dev.send(c.cmd[role]); err != nil {
 		return err
 	}
 
@@ -339,270 +579,166 @@ func (r *rom) n64ResetCart() error {
 	return nil
 }
 
-func (r *rom) n64SetAddress(addr uint32) error {
-	bA := 0
-	eA := 0
-	bB := len(r.commands) / 2
-	eB := len(r.commands) / 2
+// n64Cmds builds the commands of both channels from a profile.
+//
+// It tracks the level and direction of every pin, starting from their idle
+// state, so each write only changes the signals asked for.
+type n64Cmds struct {
+	p *Profile
+	// value and dir are indexed by the channel role, then the bus.
+	value [2][2]byte
+	dir   [2][2]byte
+	// cmd are the commands of the channels A and B.
+	cmd [2][]byte
+	// addrWait is the ALE setup delay.
+	addrWait uint16
+}
+
+// level is the level of a signal.
+type level struct {
+	s    Signal
+	high bool
+}
+
+func newN64Cmds(p *Profile, a, b []byte) *n64Cmds {
+	c := &n64Cmds{p: p, cmd: [2][]byte{a, b}, addrWait: n64AddressWait}
+	for _, s := range p.outputs() {
+		c.dir[s.role()][s.bus()] |= s.mask()
+		if s.Idle {
+			c.value[s.role()][s.bus()] |= s.mask()
+		}
+	}
+	return c
+}
+
+// writeBus appends the write of the current state of a bus.
+func (c *n64Cmds) writeBus(role, bus int) {
+	c.cmd[role] = append(c.cmd[role], 0x80|byte(bus)<<1, c.value[role][bus], c.dir[role][bus])
+}
+
+// drive sets the signals, then writes each bus changed once, in order.
+func (c *n64Cmds) drive(levels ...level) {
+	var written [2][2]bool
+	for _, l := range levels {
+		if l.high {
+			c.value[l.s.role()][l.s.bus()] |= l.s.mask()
+		} else {
+			c.value[l.s.role()][l.s.bus()] &^= l.s.mask()
+		}
+	}
+	for _, l := range levels {
+		if r, b := l.s.role(), l.s.bus(); !written[r][b] {
+			written[r][b] = true
+			c.writeBus(r, b)
+		}
+	}
+}
+
+// hold writes the bus of s again without change, for delay.
+func (c *n64Cmds) hold(s Signal) {
+	c.writeBus(s.role(), s.bus())
+}
+
+// out drives v on a port.
+func (c *n64Cmds) out(p Port, v byte) {
+	s := p.signal()
+	c.value[s.role()][s.bus()] = v
+	c.dir[s.role()][s.bus()] = 0xff
+	c.writeBus(s.role(), s.bus())
+}
+
+// release sets a port back to input.
+func (c *n64Cmds) release(p Port) {
+	s := p.signal()
+	c.value[s.role()][s.bus()] = 0
+	c.dir[s.role()][s.bus()] = 0
+	c.writeBus(s.role(), s.bus())
+}
+
+// read appends the read of a port, which returns one byte.
+func (c *n64Cmds) read(p Port) {
+	s := p.signal()
+	c.cmd[s.role()] = append(c.cmd[s.role()], 0x81|byte(s.bus())<<1)
+}
+
+// pulse pulses CS for the channel B to go on, which first waits for it.
+func (c *n64Cmds) pulse(levels ...level) {
+	c.drive(append(levels, level{c.p.CS, true})...)
+	c.drive(level{c.p.CS, false})
+
+	// Wait On I/O High
+	c.cmd[1] = append(c.cmd[1], 0x88)
+	// for delay
+	c.hold(c.p.WAIT)
+}
+
+// setAddress appends the commands latching addr.
+func (c *n64Cmds) setAddress(addr uint32) {
+	p := c.p
 
 	// ALE_H/ALE_L = ?/? -> 0/0 -> wait -> 1/0 -> 1/1,CS:1 -> 1/1,CS:0
 	// ALE_H/ALE_L = ?/? -> 0/0
-	r.commands[eA] = 0x80
-	eA++
-	r.commands[eA] = 0b0011_0001 // ALE_H, ALE_L, /RE, /WE, CS
-	eA++
-	r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-	eA++
+	c.drive(level{p.ALEH, false}, level{p.ALEL, false})
 	// wait 0 =  1.6[us]
 	// wait 1 =  2.8[us] (+1.2[us]  = 1.20u/byte = 150n/bit)
 	// wait 2 =  4.0[us] (+2.4[us]  = 1.20u/byte = 150n/bit)
 	// wait 4 =  6.6[us] (+5.0[us]  = 1.25u/byte = 156n/bit)
 	// wait 9 = 12.5[us] (+10.9[us] = 1.21u/byte = 151n/bit)
 	{
-		r.commands[eA] = 0x8f // wait
-		eA++
-		r.commands[eA] = 9 // uint16 Lo
-		eA++
-		r.commands[eA] = 0 // uint16 Hi
-		eA++
+		c.cmd[0] = append(c.cmd[0],
+			0x8f,                 // wait
+			uint8(c.addrWait),    // uint16 Lo
+			uint8(c.addrWait>>8), // uint16 Hi
+		)
 	}
 	// ALE_H/ALE_L = 0/0 -> 1/0
-	r.commands[eA] = 0x80
-	eA++
-	r.commands[eA] = 0b1011_0001 // ALE_H, ALE_L, /RE, /WE, CS
-	eA++
-	r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-	eA++
-	// ALE_H/ALE_L = 1/0 -> 1/1, CS:0->1
-	r.commands[eA] = 0x80
-	eA++
-	r.commands[eA] = 0b1111_1001 // ALE_H, ALE_L, /RE, /WE, CS
-	eA++
-	r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-	eA++
-	// CS:1->0 for delay 200[ns]
-	r.commands[eA] = 0x80
-	eA++
-	r.commands[eA] = 0b1111_0001 // ALE_H, ALE_L, /RE, /WE, CS
-	eA++
-	r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-	eA++
-
-	// Wait On I/O High
-	r.commands[eB] = 0x88
-	eB++
-	// for delay
-	r.commands[eB] = 0x80
-	eB++
-	r.commands[eB] = 0b0101_0001 // S_DAT, CLK, WAIT, /RST, CS
-	eB++
-	r.commands[eB] = 0b0101_1011 // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
-	eB++
+	c.drive(level{p.ALEH, true})
+	// ALE_H/ALE_L = 1/0 -> 1/1, CS pulse
+	c.pulse(level{p.ALEL, true})
 
 	// addr Hi
-	r.commands[eB] = 0x82
-	eB++
-	r.commands[eB] = uint8(addr >> 24) // AD15-8
-	eB++
-	r.commands[eB] = 0xff // AD15-8:Out
-	eB++
-	r.commands[eA] = 0x82
-	eA++
-	r.commands[eA] = uint8((addr >> 16) & 0xff) // AD7-0
-	eA++
-	r.commands[eA] = 0xff // AD7-0:Out
-	eA++
-	// ALE_H/ALE_L = 1/1 -> 0/1, CS:0->1
-	r.commands[eA] = 0x80
-	eA++
-	r.commands[eA] = 0b0111_1001 // ALE_H, ALE_L, /RE, /WE, CS
-	eA++
-	r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-	eA++
-	// CS:1->0 for delay
-	r.commands[eA] = 0x80
-	eA++
-	r.commands[eA] = 0b0111_0001 // ALE_H, ALE_L, /RE, /WE, CS
-	eA++
-	r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-	eA++
-
-	// Wait On I/O High
-	r.commands[eB] = 0x88
-	eB++
-	// for delay
-	r.commands[eB] = 0x80
-	eB++
-	r.commands[eB] = 0b0101_0001 // S_DAT, CLK, WAIT, /RST, CS
-	eB++
-	r.commands[eB] = 0b0101_1011 // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
-	eB++
+	c.out(p.ADHigh, uint8(addr>>24))
+	c.out(p.ADLow, uint8((addr>>16)&0xff))
+	// ALE_H/ALE_L = 1/1 -> 0/1, CS pulse
+	c.pulse(level{p.ALEH, false})
 
 	// addr Lo
-	r.commands[eB] = 0x82
-	eB++
-	r.commands[eB] = uint8((addr >> 8) & 0xff) // AD15-8
-	eB++
-	r.commands[eB] = 0xff // AD15-8:Out
-	eB++
-	r.commands[eA] = 0x82
-	eA++
-	r.commands[eA] = uint8(addr & 0xff) // AD7-0
-	eA++
-	r.commands[eA] = 0xff // AD7-0:Out
-	eA++
-	// ALE_H/ALE_L = 0/1 -> 0/0, CS:0->1
-	r.commands[eA] = 0x80
-	eA++
-	r.commands[eA] = 0b0011_1001 // ALE_H, ALE_L, /RE, /WE, CS
-	eA++
-	r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-	eA++
-	// CS:1->0 for delay
-	r.commands[eA] = 0x80
-	eA++
-	r.commands[eA] = 0b0011_0001 // ALE_H, ALE_L, /RE, /WE, CS
-	eA++
-	r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-	eA++
-
-	// Wait On I/O High
-	r.commands[eB] = 0x88
-	eB++
-	// for delay
-	r.commands[eB] = 0x80
-	eB++
-	r.commands[eB] = 0b0101_0001 // S_DAT, CLK, WAIT, /RST, CS
-	eB++
-	r.commands[eB] = 0b0101_1011 // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
-	eB++
+	c.out(p.ADHigh, uint8((addr>>8)&0xff))
+	c.out(p.ADLow, uint8(addr&0xff))
+	// ALE_H/ALE_L = 0/1 -> 0/0, CS pulse
+	c.pulse(level{p.ALEL, false})
 
 	// Bus direction
-	r.commands[eB] = 0x82
-	eB++
-	r.commands[eB] = 0x00 // AD15-8
-	eB++
-	r.commands[eB] = 0x00 // AD15-8:In
-	eB++
-	r.commands[eA] = 0x82
-	eA++
-	r.commands[eA] = 0x00 // AD7-0
-	eA++
-	r.commands[eA] = 0x00 // AD7-0:In
-	eA++
-
-	_, err := r.devB.write(r.commands[bB:eB])
-	if err != nil {
-		return err
-	}
-	_, err = r.devA.write(r.commands[bA:eA])
-	if err != nil {
-		return err
-	}
-
-	return nil
+	c.release(p.ADHigh)
+	c.release(p.ADLow)
 }
 
-func (r *rom) n64ReadROM512() ([]byte, error) {
-	bA := 0
-	eA := 0
-	bB := len(r.commands) / 2
-	eB := len(r.commands) / 2
-
+// readROM512 appends the commands reading 256 words. Each channel returns 256
+// bytes.
+func (c *n64Cmds) readROM512() {
+	p := c.p
 	for i := 0; i < 256; i++ {
 		// /RE:1->0
-		r.commands[eA] = 0x80
-		eA++
-		r.commands[eA] = 0b0001_0001 // ALE_H, ALE_L, /RE, /WE, CS
-		eA++
-		r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-		eA++
+		c.drive(level{p.RE, false})
 		// TODO: for flash?
 		// wait 15 = 1.6u + 150/bit * 8 * 15 = 19.6[us]
 		if false {
-			r.commands[eA] = 0x8f // wait
-			eA++
-			r.commands[eA] = 15 // uint16 Lo
-			eA++
-			r.commands[eA] = 0 // uint16 Hi
-			eA++
+			c.cmd[0] = append(c.cmd[0],
+				0x8f, // wait
+				15,   // uint16 Lo
+				0,    // uint16 Hi
+			)
 		}
-		// CS:0->1
-		r.commands[eA] = 0x80
-		eA++
-		r.commands[eA] = 0b0001_1001 // ALE_H, ALE_L, /RE, /WE, CS
-		eA++
-		r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-		eA++
-		// CS:1->0 for delay
-		r.commands[eA] = 0x80
-		eA++
-		r.commands[eA] = 0b0001_0001 // ALE_H, ALE_L, /RE, /WE, CS
-		eA++
-		r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-		eA++
-
-		// Wait On I/O High
-		r.commands[eB] = 0x88
-		eB++
-		// for delay
-		r.commands[eB] = 0x80
-		eB++
-		r.commands[eB] = 0b0101_0001 // S_DAT, CLK, WAIT, /RST, CS
-		eB++
-		r.commands[eB] = 0b0101_1011 // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
-		eB++
+		// CS pulse
+		c.pulse()
 
 		// read
-		r.commands[eB] = 0x83 // AD15-8
-		eB++
-		r.commands[eA] = 0x83 // AD7-0
-		eA++
+		c.read(p.ADHigh)
+		c.read(p.ADLow)
 
 		// /RE:0->1
-		r.commands[eA] = 0x80
-		eA++
-		r.commands[eA] = 0b0011_0001 // ALE_H, ALE_L, /RE, /WE, CS
-		eA++
-		r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-		eA++
+		c.drive(level{p.RE, true})
 		// for delay
-		r.commands[eA] = 0x80
-		eA++
-		r.commands[eA] = 0b0011_0001 // ALE_H, ALE_L, /RE, /WE, CS
-		eA++
-		r.commands[eA] = 0b1111_1011 // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
-		eA++
-	}
-
-	_, err := r.devB.write(r.commands[bB:eB])
-	if err != nil {
-		return nil, err
-	}
-	bB = eB
-	eB += 256
-	_, err = r.devA.write(r.commands[bA:eA])
-	if err != nil {
-		return nil, err
+		c.hold(p.RE)
 	}
-	bA = eA
-	eA += 256
-
-	err = r.devB.readAll(r.commands[bB:eB])
-	if err != nil {
-		return nil, err
-	}
-	err = r.devA.readAll(r.commands[bA:eA])
-	if err != nil {
-		return nil, err
-	}
-
-	// interleave B(hi) and A(lo)
-	result := r.commands[eB : eB+512]
-	for i := 0; i < 256; i++ {
-		result[i*2+0] = r.commands[bB+i]
-		result[i*2+1] = r.commands[bA+i]
-	}
-
-	return result, nil
 }
@@ -0,0 +1,300 @@
+package d2xx
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The golden commands below are the ones hand-written for the n64 board before
+// the wiring was described by profiles/n64.json. The commands generated from
+// the embedded profile must not differ by a single byte.
+
+func goldenSetupPinsA() []byte {
+	return []byte{
+		0x80,
+		0b1011_0001, // ALE_H:1, ALE_L:0, /RE:1, /WE:1, CS:0, (MISO:0, MOSI:0, SCLK:1)
+		0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out, (MISO:In, MOSI:Out, SCLK:Out)
+		0x82,
+		0x00, // AD7-0:0
+		0x00, // AD7-0:In
+	}
+}
+
+func goldenSetupPinsB() []byte {
+	return []byte{
+		0x80,
+		0b0101_0001, // S_DAT:0, CLK:1, WAIT:0, /RST:1, CS:0, (MISO:0, MOSI:0, SCLK:1)
+		0b0101_1011, // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out, (MISO:In, MOSI:Out, SCLK:Out)
+		0x82,
+		0x00, // AD15-8:0
+		0x00, // AD15-8:In
+	}
+}
+
+// goldenResetCart returns the two commands of channel B asserting, then
+// releasing /RST.
+func goldenResetCart() ([]byte, []byte) {
+	return []byte{
+		0x80,
+		0b0100_0001, // S_DAT, CLK, WAIT, /RST:0, CS
+		0b0101_1011, // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
+	}, []byte{
+		0x80,
+		0b0101_0001, // S_DAT, CLK, WAIT, /RST:1, CS
+		0b0101_1011, // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
+	}
+}
+
+func goldenSetAddress(a, b []byte, addr uint32) ([]byte, []byte) {
+	// ALE_H/ALE_L = ?/? -> 0/0
+	a = append(a,
+		0x80,
+		0b0011_0001, // ALE_H, ALE_L, /RE, /WE, CS
+		0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+	)
+	a = append(a,
+		0x8f, // wait
+		9,    // uint16 Lo
+		0,    // uint16 Hi
+	)
+	// ALE_H/ALE_L = 0/0 -> 1/0
+	a = append(a,
+		0x80,
+		0b1011_0001, // ALE_H, ALE_L, /RE, /WE, CS
+		0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+	)
+	// ALE_H/ALE_L = 1/0 -> 1/1, CS:0->1
+	a = append(a,
+		0x80,
+		0b1111_1001, // ALE_H, ALE_L, /RE, /WE, CS
+		0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+	)
+	// CS:1->0 for delay 200[ns]
+	a = append(a,
+		0x80,
+		0b1111_0001, // ALE_H, ALE_L, /RE, /WE, CS
+		0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+	)
+
+	// Wait On I/O High
+	b = append(b, 0x88)
+	// for delay
+	b = append(b,
+		0x80,
+		0b0101_0001, // S_DAT, CLK, WAIT, /RST, CS
+		0b0101_1011, // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
+	)
+
+	// addr Hi
+	b = append(b,
+		0x82,
+		uint8(addr>>24), // AD15-8
+		0xff,            // AD15-8:Out
+	)
+	a = append(a,
+		0x82,
+		uint8((addr>>16)&0xff), // AD7-0
+		0xff,                   // AD7-0:Out
+	)
+	// ALE_H/ALE_L = 1/1 -> 0/1, CS:0->1
+	a = append(a,
+		0x80,
+		0b0111_1001, // ALE_H, ALE_L, /RE, /WE, CS
+		0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+	)
+	// CS:1->0 for delay
+	a = append(a,
+		0x80,
+		0b0111_0001, // ALE_H, ALE_L, /RE, /WE, CS
+		0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+	)
+
+	// Wait On I/O High
+	b = append(b, 0x88)
+	// for delay
+	b = append(b,
+		0x80,
+		0b0101_0001, // S_DAT, CLK, WAIT, /RST, CS
+		0b0101_1011, // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
+	)
+
+	// addr Lo
+	b = append(b,
+		0x82,
+		uint8((addr>>8)&0xff), // AD15-8
+		0xff,                  // AD15-8:Out
+	)
+	a = append(a,
+		0x82,
+		uint8(addr&0xff), // AD7-0
+		0xff,             // AD7-0:Out
+	)
+	// ALE_H/ALE_L = 0/1 -> 0/0, CS:0->1
+	a = append(a,
+		0x80,
+		0b0011_1001, // ALE_H, ALE_L, /RE, /WE, CS
+		0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+	)
+	// CS:1->0 for delay
+	a = append(a,
+		0x80,
+		0b0011_0001, // ALE_H, ALE_L, /RE, /WE, CS
+		0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+	)
+
+	// Wait On I/O High
+	b = append(b, 0x88)
+	// for delay
+	b = append(b,
+		0x80,
+		0b0101_0001, // S_DAT, CLK, WAIT, /RST, CS
+		0b0101_1011, // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
+	)
+
+	// Bus direction
+	b = append(b,
+		0x82,
+		0x00, // AD15-8
+		0x00, // AD15-8:In
+	)
+	a = append(a,
+		0x82,
+		0x00, // AD7-0
+		0x00, // AD7-0:In
+	)
+
+	return a, b
+}
+
+func goldenReadROM512(a, b []byte) ([]byte, []byte) {
+	for i := 0; i < 256; i++ {
+		// /RE:1->0
+		a = append(a,
+			0x80,
+			0b0001_0001, // ALE_H, ALE_L, /RE, /WE, CS
+			0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+		)
+		// CS:0->1
+		a = append(a,
+			0x80,
+			0b0001_1001, // ALE_H, ALE_L, /RE, /WE, CS
+			0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+		)
+		// CS:1->0 for delay
+		a = append(a,
+			0x80,
+			0b0001_0001, // ALE_H, ALE_L, /RE, /WE, CS
+			0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+		)
+
+		// Wait On I/O High
+		b = append(b, 0x88)
+		// for delay
+		b = append(b,
+			0x80,
+			0b0101_0001, // S_DAT, CLK, WAIT, /RST, CS
+			0b0101_1011, // S_DAT:In, CLK:Out, WAIT:In, /RST:Out, CS:Out
+		)
+
+		// read
+		b = append(b, 0x83) // AD15-8
+		a = append(a, 0x83) // AD7-0
+
+		// /RE:0->1
+		a = append(a,
+			0x80,
+			0b0011_0001, // ALE_H, ALE_L, /RE, /WE, CS
+			0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+		)
+		// for delay
+		a = append(a,
+			0x80,
+			0b0011_0001, // ALE_H, ALE_L, /RE, /WE, CS
+			0b1111_1011, // ALE_H:Out, ALE_L:Out, /RE:Out, /WE:Out, CS:Out
+		)
+	}
+
+	return a, b
+}
+
+func n64Profile(t *testing.T) *Profile {
+	t.Helper()
+	p, err := LookupProfile(DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func checkCmd(t *testing.T, what string, got, want []byte) {
+	t.Helper()
+	if bytes.Equal(got, want) {
+		return
+	}
+	i := 0
+	for i < len(got) && i < len(want) && got[i] == want[i] {
+		i++
+	}
+	t.Errorf("%s: %d bytes, want %d; first difference at byte %d:\ngot  % x\nwant % x", what, len(got), len(want), i, got[i:min(i+16, len(got))], want[i:min(i+16, len(want))])
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestN64SetupPins(t *testing.T) {
+	p := n64Profile(t)
+	for role, want := range [][]byte{goldenSetupPinsA(), goldenSetupPinsB()} {
+		c := newN64Cmds(p, nil, nil)
+		c.writeBus(role, 0)
+		c.writeBus(role, 1)
+		checkCmd(t, "setup "+[]string{"A", "B"}[role], c.cmd[role], want)
+		if other := c.cmd[1-role]; len(other) != 0 {
+			t.Errorf("setup %s: unexpected commands % x for the other channel", []string{"A", "B"}[role], other)
+		}
+	}
+}
+
+func TestN64ResetCart(t *testing.T) {
+	p := n64Profile(t)
+	if role := p.RST.role(); role != 1 {
+		t.Fatalf("RST is on the channel role %d, want B", role)
+	}
+	wantLow, wantHigh := goldenResetCart()
+	c := newN64Cmds(p, nil, nil)
+	c.drive(level{p.RST, !p.RST.Idle})
+	checkCmd(t, "reset /RST:0", c.cmd[1], wantLow)
+	c.cmd[1] = c.cmd[1][:0]
+	c.drive(level{p.RST, p.RST.Idle})
+	checkCmd(t, "reset /RST:1", c.cmd[1], wantHigh)
+	if len(c.cmd[0]) != 0 {
+		t.Errorf("reset: unexpected commands % x for the channel A", c.cmd[0])
+	}
+}
+
+func TestN64SetAddress(t *testing.T) {
+	p := n64Profile(t)
+	for _, addr := range []uint32{0x10000000, 0x10000040, 0x1fc007fe, 0x12345678, 0xffffffff, 0} {
+		c := newN64Cmds(p, nil, nil)
+		c.setAddress(addr)
+		wantA, wantB := goldenSetAddress(nil, nil, addr)
+		checkCmd(t, "setAddress A", c.cmd[0], wantA)
+		checkCmd(t, "setAddress B", c.cmd[1], wantB)
+	}
+}
+
+func TestN64Read512(t *testing.T) {
+	p := n64Profile(t)
+	// a read as queued by Read512Async: latch the address, then read
+	const addr = 0x10001000
+	c := newN64Cmds(p, nil, nil)
+	c.setAddress(addr)
+	c.readROM512()
+	wantA, wantB := goldenSetAddress(nil, nil, addr)
+	wantA, wantB = goldenReadROM512(wantA, wantB)
+	checkCmd(t, "read A", c.cmd[0], wantA)
+	checkCmd(t, "read B", c.cmd[1], wantB)
+}
@@ -0,0 +1,178 @@
+package d2xx
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Parity is the parity of a UART.
+type Parity uint8
+
+const (
+	ParityNone  Parity = 0
+	ParityOdd   Parity = 1
+	ParityEven  Parity = 2
+	ParityMark  Parity = 3
+	ParitySpace Parity = 4
+)
+
+// StopBits is the number of stop bits of a UART.
+type StopBits uint8
+
+const (
+	StopBits1 StopBits = 0
+	StopBits2 StopBits = 2
+)
+
+// SerialConfig is the line configuration of a UART.
+type SerialConfig struct {
+	BaudRate int64
+	// DataBits is 7 or 8.
+	DataBits    int
+	Parity      Parity
+	StopBits    StopBits
+	FlowControl FlowControl
+}
+
+// DefaultSerialConfig is 115200 8N1 without flow control.
+var DefaultSerialConfig = SerialConfig{
+	BaudRate:    115200,
+	DataBits:    8,
+	Parity:      ParityNone,
+	StopBits:    StopBits1,
+	FlowControl: FlowNone,
+}
+
+// ModemStatus is the modem and line status of a UART.
+type ModemStatus uint32
+
+// CTS returns true if Clear To Send is active.
+func (m ModemStatus) CTS() bool { return m&0x10 != 0 }
+
+// DSR returns true if Data Set Ready is active.
+func (m ModemStatus) DSR() bool { return m&0x20 != 0 }
+
+// RI returns true if Ring Indicator is active.
+func (m ModemStatus) RI() bool { return m&0x40 != 0 }
+
+// DCD returns true if Data Carrier Detect is active.
+func (m ModemStatus) DCD() bool { return m&0x80 != 0 }
+
+// Overrun returns true if received data was lost.
+func (m ModemStatus) Overrun() bool { return m&0x0200 != 0 }
+
+// ParityError returns true if a byte was received with a bad parity.
+func (m ModemStatus) ParityError() bool { return m&0x0400 != 0 }
+
+// FramingError returns true if a byte was received without a valid stop bit.
+func (m ModemStatus) FramingError() bool { return m&0x0800 != 0 }
+
+// Break returns true if a break condition was received.
+func (m ModemStatus) Break() bool { return m&0x1000 != 0 }
+
+func (m ModemStatus) String() string {
+	return fmt.Sprintf("CTS:%t DSR:%t RI:%t DCD:%t OE:%t PE:%t FE:%t BI:%t",
+		m.CTS(), m.DSR(), m.RI(), m.DCD(), m.Overrun(), m.ParityError(), m.FramingError(), m.Break())
+}
+
+// Serial is a channel used as a serial port.
+//
+// Read, Write and Close make it an io.ReadWriteCloser.
+type Serial struct {
+	*Device
+}
+
+// OpenSerial opens the i-th device as a serial port. cfg can be nil for
+// DefaultSerialConfig.
+//
+// opts can be nil for DefaultOptions, except that ReadTimeout is 0: Read blocks
+// until data arrives, as a console fed through io.Copy expects.
+func OpenSerial(i int, cfg *SerialConfig, opts *Options) (*Serial, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+		opts.ReadTimeout = 0
+	}
+	d, err := Open(i, opts)
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewSerial(d, cfg)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSerial switches an opened device to UART mode. cfg can be nil for
+// DefaultSerialConfig.
+//
+// Read keeps the ReadTimeout the device was opened with; a console needs 0 to
+// wait for input indefinitely.
+func NewSerial(d *Device, cfg *SerialConfig) (*Serial, error) {
+	if cfg == nil {
+		cfg = &DefaultSerialConfig
+	}
+	if err := d.setBitMode(0, BitModeReset); err != nil {
+		return nil, err
+	}
+	s := &Serial{d}
+	if err := s.Configure(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Configure changes the line configuration.
+func (s *Serial) Configure(cfg *SerialConfig) error {
+	if cfg.BaudRate <= 0 {
+		return errors.New("d2xx: invalid baud rate")
+	}
+	if cfg.DataBits != 7 && cfg.DataBits != 8 {
+		return errors.New("d2xx: DataBits must be 7 or 8")
+	}
+	if cfg.Parity > ParitySpace {
+		return errors.New("d2xx: invalid Parity")
+	}
+	if cfg.StopBits != StopBits1 && cfg.StopBits != StopBits2 {
+		return errors.New("d2xx: invalid StopBits")
+	}
+	if err := s.setBaudRate(cfg.BaudRate); err != nil {
+		return err
+	}
+	if e := s.h.d2xxSetDataCharacteristics(byte(cfg.DataBits), byte(cfg.StopBits), byte(cfg.Parity)); e != 0 {
+		return toErr("SetDataCharacteristics", e)
+	}
+	xon, xoff := s.opts.XOn, s.opts.XOff
+	if cfg.FlowControl == FlowXOnXOff && xon == 0 && xoff == 0 {
+		xon, xoff = 0x11, 0x13
+	}
+	if e := s.h.d2xxSetFlowControl(uint16(cfg.FlowControl), xon, xoff); e != 0 {
+		return toErr("SetFlowControl", e)
+	}
+	return nil
+}
+
+// SetBreak starts or stops sending a break condition.
+func (s *Serial) SetBreak(on bool) error {
+	return toErr("SetBreak", s.h.d2xxSetBreak(on))
+}
+
+// SetDTR sets the Data Terminal Ready output.
+func (s *Serial) SetDTR(on bool) error {
+	return toErr("SetDtr", s.h.d2xxSetDtr(on))
+}
+
+// SetRTS sets the Request To Send output.
+func (s *Serial) SetRTS(on bool) error {
+	return toErr("SetRts", s.h.d2xxSetRts(on))
+}
+
+// ModemStatus returns the modem and line status.
+func (s *Serial) ModemStatus() (ModemStatus, error) {
+	m, e := s.h.d2xxGetModemStatus()
+	if e != 0 {
+		return 0, toErr("GetModemStatus", e)
+	}
+	return ModemStatus(m), nil
+}
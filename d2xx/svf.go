@@ -0,0 +1,449 @@
+package d2xx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PlaySVF runs the Serial Vector Format file read from r on the JTAG chain.
+//
+// TDO is checked against the expected values and the first mismatch stops the
+// playback. PIO and PIOMAP are not supported, TRST is ignored since there is
+// no TRST pin.
+func (j *JTAG) PlaySVF(r io.Reader) error {
+	p := svfPlayer{j: j, endIR: RunTestIdle, endDR: RunTestIdle, runState: RunTestIdle, runEnd: RunTestIdle}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 16*1024*1024)
+	var stmt strings.Builder
+	line := 0
+	start := 0
+	for s.Scan() {
+		line++
+		l := s.Text()
+		if i := strings.Index(l, "!"); i >= 0 {
+			l = l[:i]
+		}
+		if i := strings.Index(l, "//"); i >= 0 {
+			l = l[:i]
+		}
+		for {
+			if stmt.Len() == 0 && strings.TrimSpace(l) != "" {
+				start = line
+			}
+			i := strings.IndexByte(l, ';')
+			if i < 0 {
+				stmt.WriteString(l)
+				stmt.WriteByte(' ')
+				break
+			}
+			stmt.WriteString(l[:i])
+			if err := p.run(stmt.String()); err != nil {
+				return fmt.Errorf("d2xx: SVF line %d: %w", start, err)
+			}
+			stmt.Reset()
+			l = l[i+1:]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if strings.TrimSpace(stmt.String()) != "" {
+		return fmt.Errorf("d2xx: SVF line %d: unterminated statement", start)
+	}
+	return nil
+}
+
+// svfScan is the state kept for each of SIR, SDR, HIR, HDR, TIR and TDR.
+//
+// TDI, MASK and SMASK are remembered for the next command of the same kind as
+// long as the length doesn't change.
+type svfScan struct {
+	bits  int
+	tdi   []byte
+	tdo   []byte
+	mask  []byte
+	smask []byte
+}
+
+type svfPlayer struct {
+	j        *JTAG
+	endIR    TAPState
+	endDR    TAPState
+	runState TAPState
+	runEnd   TAPState
+	sir      svfScan
+	sdr      svfScan
+	hir      svfScan
+	hdr      svfScan
+	tir      svfScan
+	tdr      svfScan
+}
+
+var svfStates = map[string]TAPState{
+	"RESET":     TestLogicReset,
+	"IDLE":      RunTestIdle,
+	"DRSELECT":  SelectDRScan,
+	"DRCAPTURE": CaptureDR,
+	"DRSHIFT":   ShiftDR,
+	"DREXIT1":   Exit1DR,
+	"DRPAUSE":   PauseDR,
+	"DREXIT2":   Exit2DR,
+	"DRUPDATE":  UpdateDR,
+	"IRSELECT":  SelectIRScan,
+	"IRCAPTURE": CaptureIR,
+	"IRSHIFT":   ShiftIR,
+	"IREXIT1":   Exit1IR,
+	"IRPAUSE":   PauseIR,
+	"IREXIT2":   Exit2IR,
+	"IRUPDATE":  UpdateIR,
+}
+
+func svfState(s string) (TAPState, error) {
+	st, ok := svfStates[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown state %q", s)
+	}
+	return st, nil
+}
+
+func svfStableState(s string) (TAPState, error) {
+	st, err := svfState(s)
+	if err == nil && !st.stable() {
+		err = fmt.Errorf("%s is not a stable state", s)
+	}
+	return st, err
+}
+
+// svfTokens splits a statement in upper case words. A parenthesized hex
+// string is returned as a single token, without the parenthesis nor spaces.
+func svfTokens(stmt string) ([]string, error) {
+	var tokens []string
+	for {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			return tokens, nil
+		}
+		if stmt[0] == '(' {
+			i := strings.IndexByte(stmt, ')')
+			if i < 0 {
+				return nil, fmt.Errorf("missing ')'")
+			}
+			tokens = append(tokens, "("+strings.Join(strings.Fields(stmt[1:i]), "")+")")
+			stmt = stmt[i+1:]
+			continue
+		}
+		i := strings.IndexAny(stmt, " \t\r\n(")
+		if i < 0 {
+			i = len(stmt)
+		}
+		tokens = append(tokens, strings.ToUpper(stmt[:i]))
+		stmt = stmt[i:]
+	}
+}
+
+// svfHex parses a parenthesized hex string into bits LSB first.
+func svfHex(tok string, bits int) ([]byte, error) {
+	if len(tok) < 2 || tok[0] != '(' || tok[len(tok)-1] != ')' {
+		return nil, fmt.Errorf("expected hex string, got %q", tok)
+	}
+	hex := tok[1 : len(tok)-1]
+	b := make([]byte, (bits+7)/8)
+	for i := 0; i < len(hex); i++ {
+		v, err := strconv.ParseUint(hex[len(hex)-1-i:len(hex)-i], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex string %q", hex)
+		}
+		if v == 0 {
+			continue
+		}
+		if i*4 >= bits || (bits-i*4 < 4 && v>>uint(bits-i*4) != 0) {
+			return nil, fmt.Errorf("hex string %q is longer than %d bits", hex, bits)
+		}
+		b[i/2] |= byte(v) << (4 * (i % 2))
+	}
+	return b, nil
+}
+
+// parse updates the scan state from the arguments of a xIR/xDR command.
+func (s *svfScan) parse(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing length")
+	}
+	bits, err := strconv.Atoi(args[0])
+	if err != nil || bits < 0 {
+		return fmt.Errorf("invalid length %q", args[0])
+	}
+	if bits != s.bits {
+		*s = svfScan{bits: bits, tdi: make([]byte, (bits+7)/8), mask: ones(bits), smask: ones(bits)}
+	}
+	s.tdo = nil
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return fmt.Errorf("missing value for %s", args[i])
+		}
+		v, err := svfHex(args[i+1], bits)
+		if err != nil {
+			return err
+		}
+		switch args[i] {
+		case "TDI":
+			s.tdi = v
+		case "TDO":
+			s.tdo = v
+		case "MASK":
+			s.mask = v
+		case "SMASK":
+			s.smask = v
+		default:
+			return fmt.Errorf("unknown parameter %s", args[i])
+		}
+	}
+	return nil
+}
+
+// svfConcat concatenates the bit vectors of the header, the scan and the
+// trailer, in the order they are shifted in.
+func svfConcat(parts ...svfScan) (tdi, tdo, mask []byte, bits int, check bool) {
+	for _, p := range parts {
+		bits += p.bits
+	}
+	tdi = make([]byte, (bits+7)/8)
+	tdo = make([]byte, len(tdi))
+	mask = make([]byte, len(tdi))
+	o := 0
+	for _, p := range parts {
+		for i := 0; i < p.bits; i++ {
+			m := byte(1) << (i % 8)
+			d := byte(1) << ((o + i) % 8)
+			if p.tdi[i/8]&m != 0 {
+				tdi[(o+i)/8] |= d
+			}
+			if p.tdo != nil {
+				check = true
+				if p.tdo[i/8]&m != 0 {
+					tdo[(o+i)/8] |= d
+				}
+				if p.mask[i/8]&m != 0 {
+					mask[(o+i)/8] |= d
+				}
+			}
+		}
+		o += p.bits
+	}
+	return
+}
+
+func (p *svfPlayer) run(stmt string) error {
+	tokens, err := svfTokens(stmt)
+	if err != nil || len(tokens) == 0 {
+		return err
+	}
+	args := tokens[1:]
+	switch tokens[0] {
+	case "ENDIR", "ENDDR":
+		if len(args) != 1 {
+			return fmt.Errorf("%s expects one state", tokens[0])
+		}
+		st, err := svfStableState(args[0])
+		if err != nil {
+			return err
+		}
+		if tokens[0] == "ENDIR" {
+			p.endIR = st
+		} else {
+			p.endDR = st
+		}
+		return nil
+	case "FREQUENCY":
+		hz, err := svfFrequency(args)
+		if err != nil {
+			return err
+		}
+		_, err = p.j.SetFrequency(hz)
+		return err
+	case "HIR":
+		return p.hir.parse(args)
+	case "HDR":
+		return p.hdr.parse(args)
+	case "TIR":
+		return p.tir.parse(args)
+	case "TDR":
+		return p.tdr.parse(args)
+	case "SIR":
+		if err := p.sir.parse(args); err != nil {
+			return err
+		}
+		return p.scan(ShiftIR, p.endIR, p.hir, p.sir, p.tir)
+	case "SDR":
+		if err := p.sdr.parse(args); err != nil {
+			return err
+		}
+		return p.scan(ShiftDR, p.endDR, p.hdr, p.sdr, p.tdr)
+	case "RUNTEST":
+		return p.runTest(args)
+	case "STATE":
+		if len(args) == 0 {
+			return fmt.Errorf("STATE expects at least one state")
+		}
+		for _, a := range args {
+			st, err := svfState(a)
+			if err != nil {
+				return err
+			}
+			if err := p.j.GotoState(st); err != nil {
+				return err
+			}
+		}
+		if !p.j.state.stable() {
+			return fmt.Errorf("STATE must end in a stable state")
+		}
+		return nil
+	case "TRST":
+		return nil
+	default:
+		return fmt.Errorf("unsupported command %s", tokens[0])
+	}
+}
+
+func (p *svfPlayer) scan(s, end TAPState, parts ...svfScan) error {
+	tdi, tdo, mask, bits, check := svfConcat(parts...)
+	if bits == 0 {
+		return p.j.GotoState(end)
+	}
+	got, err := p.j.shift(s, tdi, bits, end)
+	if err != nil {
+		return err
+	}
+	if !check {
+		return nil
+	}
+	for i := range got {
+		got[i] &= mask[i]
+		tdo[i] &= mask[i]
+	}
+	if !bytes.Equal(got, tdo) {
+		return fmt.Errorf("TDO mismatch: got %x, expected %x (LSB first)", got, tdo)
+	}
+	return nil
+}
+
+// svfMaxFrequency is the highest TCK frequency: the 60MHz master clock
+// divided by two.
+const svfMaxFrequency = 30000000
+
+// svfFrequency returns the TCK frequency set by the arguments of FREQUENCY.
+// Without arguments, or above the highest one, TCK runs at full speed.
+func svfFrequency(args []string) (int64, error) {
+	if len(args) == 0 {
+		return svfMaxFrequency, nil
+	}
+	if len(args) != 2 || args[1] != "HZ" {
+		return 0, fmt.Errorf("invalid FREQUENCY")
+	}
+	f, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	if f <= 0 {
+		return 0, fmt.Errorf("invalid FREQUENCY %s", args[0])
+	}
+	if f > svfMaxFrequency {
+		return svfMaxFrequency, nil
+	}
+	return int64(f), nil
+}
+
+// svfRunTest is a parsed RUNTEST command.
+type svfRunTest struct {
+	state   TAPState
+	end     TAPState
+	count   int
+	minTime time.Duration
+}
+
+// clocks returns how many times TCK is clocked at hz: run_count, or more if
+// it takes less than min_time.
+func (t svfRunTest) clocks(hz int64) int {
+	n := t.count
+	if m := int(math.Ceil(t.minTime.Seconds() * float64(hz))); m > n {
+		n = m
+	}
+	return n
+}
+
+// parseRunTest parses the arguments of:
+//
+//	RUNTEST [run_state] run_count run_clk [min_time SEC [MAXIMUM max_time SEC]] [ENDSTATE end_state]
+//	RUNTEST [run_state] min_time SEC [MAXIMUM max_time SEC] [ENDSTATE end_state]
+//
+// state and end are the run and end states of the previous RUNTEST; both
+// persist. A new run_state also becomes the default end_state.
+func parseRunTest(args []string, state, end TAPState) (svfRunTest, error) {
+	t := svfRunTest{state: state, end: end}
+	if len(args) != 0 {
+		if st, err := svfStableState(args[0]); err == nil {
+			t.state = st
+			t.end = st
+			args = args[1:]
+		}
+	}
+	for len(args) != 0 {
+		if len(args) < 2 {
+			return t, fmt.Errorf("invalid RUNTEST")
+		}
+		switch args[1] {
+		case "TCK", "SCK":
+			n, err := strconv.ParseFloat(args[0], 64)
+			if err != nil {
+				return t, err
+			}
+			t.count = int(n)
+		case "SEC":
+			f, err := strconv.ParseFloat(args[0], 64)
+			if err != nil {
+				return t, err
+			}
+			t.minTime = time.Duration(f * float64(time.Second))
+		default:
+			if args[0] == "MAXIMUM" {
+				// The maximum time is always met, there's nothing to wait for.
+				if len(args) < 3 || args[2] != "SEC" {
+					return t, fmt.Errorf("invalid RUNTEST MAXIMUM")
+				}
+				args = args[1:]
+				break
+			}
+			if args[0] == "ENDSTATE" {
+				st, err := svfStableState(args[1])
+				if err != nil {
+					return t, err
+				}
+				t.end = st
+				break
+			}
+			return t, fmt.Errorf("invalid RUNTEST parameter %s", args[0])
+		}
+		args = args[2:]
+	}
+	return t, nil
+}
+
+// runTest runs a RUNTEST command. TCK keeps running for min_time rather than
+// being stopped while waiting.
+func (p *svfPlayer) runTest(args []string) error {
+	t, err := parseRunTest(args, p.runState, p.runEnd)
+	if err != nil {
+		return err
+	}
+	p.runState, p.runEnd = t.state, t.end
+	if err := p.j.RunTest(t.state, t.clocks(p.j.hz)); err != nil {
+		return err
+	}
+	return p.j.GotoState(t.end)
+}
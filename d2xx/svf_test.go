@@ -0,0 +1,196 @@
+package d2xx
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTMSPath(t *testing.T) {
+	tests := []struct {
+		from, to TAPState
+		bits     uint32
+		n        int
+	}{
+		{RunTestIdle, RunTestIdle, 0, 0},
+		{RunTestIdle, ShiftDR, 0b001, 3},
+		{RunTestIdle, ShiftIR, 0b0011, 4},
+		{ShiftDR, RunTestIdle, 0b011, 3},
+		{ShiftIR, PauseIR, 0b01, 2},
+		{PauseDR, ShiftDR, 0b01, 2},
+		{TestLogicReset, RunTestIdle, 0b0, 1},
+		{PauseIR, TestLogicReset, 0x1f, 5},
+	}
+	for _, tt := range tests {
+		bits, n := tmsPath(tt.from, tt.to)
+		if bits != tt.bits || n != tt.n {
+			t.Errorf("tmsPath(%s, %s) = %#b, %d; want %#b, %d", tt.from, tt.to, bits, n, tt.bits, tt.n)
+		}
+	}
+}
+
+func TestTMSPathReachesState(t *testing.T) {
+	for from := TestLogicReset; from <= UpdateIR; from++ {
+		for to := TestLogicReset; to <= UpdateIR; to++ {
+			bits, n := tmsPath(from, to)
+			s := from
+			for i := 0; i < n; i++ {
+				s = tapNext[s][bits>>i&1]
+			}
+			if s != to {
+				t.Errorf("tmsPath(%s, %s) leads to %s", from, to, s)
+			}
+		}
+	}
+}
+
+func TestSVFHex(t *testing.T) {
+	tests := []struct {
+		tok  string
+		bits int
+		want []byte
+	}{
+		{"(a5)", 8, []byte{0xa5}},
+		{"(A5)", 8, []byte{0xa5}},
+		{"(1FF)", 9, []byte{0xff, 0x01}},
+		{"(0001)", 1, []byte{0x01}},
+		{"(12)", 5, []byte{0x12}},
+		{"(0)", 12, []byte{0x00, 0x00}},
+		{"()", 4, []byte{0x00}},
+	}
+	for _, tt := range tests {
+		got, err := svfHex(tt.tok, tt.bits)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("svfHex(%q, %d) = %x, %v; want %x", tt.tok, tt.bits, got, err, tt.want)
+		}
+	}
+	for _, tt := range []struct {
+		tok  string
+		bits int
+	}{
+		{"(1FF)", 8},
+		{"(3)", 1},
+		{"(20)", 5},
+		{"(G)", 4},
+		{"A5", 8},
+		{"(A5", 8},
+	} {
+		if got, err := svfHex(tt.tok, tt.bits); err == nil {
+			t.Errorf("svfHex(%q, %d) = %x; want an error", tt.tok, tt.bits, got)
+		}
+	}
+}
+
+func TestSVFTokens(t *testing.T) {
+	tests := []struct {
+		stmt string
+		want []string
+	}{
+		{"", nil},
+		{" \t", nil},
+		{"sir 8 tdi (a5) tdo(ff)", []string{"SIR", "8", "TDI", "(a5)", "TDO", "(ff)"}},
+		{"SDR 16 TDI (12 34\n56)", []string{"SDR", "16", "TDI", "(123456)"}},
+		{"RUNTEST\tIDLE 1E3 TCK", []string{"RUNTEST", "IDLE", "1E3", "TCK"}},
+	}
+	for _, tt := range tests {
+		got, err := svfTokens(tt.stmt)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("svfTokens(%q) = %q, %v; want %q", tt.stmt, got, err, tt.want)
+		}
+	}
+	if got, err := svfTokens("SIR 8 TDI (a5"); err == nil {
+		t.Errorf("svfTokens() = %q; want an error", got)
+	}
+}
+
+func TestParseRunTest(t *testing.T) {
+	tests := []struct {
+		args       []string
+		state, end TAPState
+		want       svfRunTest
+	}{
+		{
+			[]string{"IDLE", "100", "TCK"}, RunTestIdle, RunTestIdle,
+			svfRunTest{state: RunTestIdle, end: RunTestIdle, count: 100},
+		},
+		{
+			[]string{"100", "TCK", "ENDSTATE", "DRPAUSE"}, RunTestIdle, RunTestIdle,
+			svfRunTest{state: RunTestIdle, end: PauseDR, count: 100},
+		},
+		// The end state persists.
+		{
+			[]string{"10", "TCK"}, RunTestIdle, PauseDR,
+			svfRunTest{state: RunTestIdle, end: PauseDR, count: 10},
+		},
+		// A new run state becomes the end state.
+		{
+			[]string{"IRPAUSE", "10", "TCK"}, RunTestIdle, PauseDR,
+			svfRunTest{state: PauseIR, end: PauseIR, count: 10},
+		},
+		{
+			[]string{"DRPAUSE", "0.5", "SEC"}, RunTestIdle, RunTestIdle,
+			svfRunTest{state: PauseDR, end: PauseDR, minTime: 500 * time.Millisecond},
+		},
+		{
+			[]string{"1E2", "TCK", "0.25", "SEC", "MAXIMUM", "1", "SEC", "ENDSTATE", "RESET"}, RunTestIdle, RunTestIdle,
+			svfRunTest{state: RunTestIdle, end: TestLogicReset, count: 100, minTime: 250 * time.Millisecond},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseRunTest(tt.args, tt.state, tt.end)
+		if err != nil || got != tt.want {
+			t.Errorf("parseRunTest(%q, %s, %s) = %+v, %v; want %+v", tt.args, tt.state, tt.end, got, err, tt.want)
+		}
+	}
+	for _, args := range [][]string{
+		{"100"},
+		{"100", "TCK", "ENDSTATE", "DRSHIFT"},
+		{"100", "TCK", "MAXIMUM", "1"},
+		{"FOO", "BAR"},
+	} {
+		if got, err := parseRunTest(args, RunTestIdle, RunTestIdle); err == nil {
+			t.Errorf("parseRunTest(%q) = %+v; want an error", args, got)
+		}
+	}
+}
+
+func TestRunTestClocks(t *testing.T) {
+	tests := []struct {
+		t    svfRunTest
+		hz   int64
+		want int
+	}{
+		{svfRunTest{count: 100}, 1000000, 100},
+		{svfRunTest{count: 100, minTime: time.Millisecond}, 1000000, 1000},
+		{svfRunTest{count: 5000, minTime: time.Millisecond}, 1000000, 5000},
+		{svfRunTest{minTime: 500 * time.Millisecond}, 30000000, 15000000},
+	}
+	for _, tt := range tests {
+		if got := tt.t.clocks(tt.hz); got != tt.want {
+			t.Errorf("%+v.clocks(%d) = %d; want %d", tt.t, tt.hz, got, tt.want)
+		}
+	}
+}
+
+func TestSVFFrequency(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int64
+	}{
+		{nil, svfMaxFrequency},
+		{[]string{"1E6", "HZ"}, 1000000},
+		{[]string{"5E7", "HZ"}, svfMaxFrequency},
+	}
+	for _, tt := range tests {
+		got, err := svfFrequency(tt.args)
+		if err != nil || got != tt.want {
+			t.Errorf("svfFrequency(%q) = %d, %v; want %d", tt.args, got, err, tt.want)
+		}
+	}
+	for _, args := range [][]string{{"1E6"}, {"0", "HZ"}, {"fast", "HZ"}} {
+		if got, err := svfFrequency(args); err == nil {
+			t.Errorf("svfFrequency(%q) = %d; want an error", args, got)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package d2xx
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// DeviceEvent is a FT2232H board being attached or detached.
+type DeviceEvent struct {
+	Attached bool
+	// Serial is the chip serial number, without the channel suffix. It is
+	// empty for a board with a blank EEPROM.
+	Serial string
+	// Info is the channel A of the board, as enumerated when attached.
+	Info DeviceInfo
+}
+
+// Watch polls the device list every interval until ctx is done, and sends an
+// event each time a FT2232H board is attached or detached. The boards already
+// connected are enumerated before Watch returns, and sent first as attached.
+// The channel is closed once ctx is done.
+//
+// The boards are tracked by the location ID of their channel A, so a board
+// with a blank EEPROM is reported too, with an empty serial number. The driver
+// hides the serial number of an opened device, so a board opened before Watch
+// is called isn't reported; a board seen then opened stays attached until
+// unplugged.
+func Watch(ctx context.Context, interval time.Duration) <-chan DeviceEvent {
+	ch := make(chan DeviceEvent)
+	// known maps the location ID of the channel A to the boards attached.
+	known := map[uint32]DeviceEvent{}
+	pending := watchPoll(known)
+	go func() {
+		defer close(ch)
+		for {
+			for _, ev := range pending {
+				select {
+				case ch <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			pending = watchPoll(known)
+		}
+	}()
+	return ch
+}
+
+// watchPoll enumerates the devices, updates known and returns the changes.
+func watchPoll(known map[uint32]DeviceEvent) []DeviceEvent {
+	devs, err := ListDevices()
+	if err != nil {
+		// A transient enumeration failure isn't a detach.
+		return nil
+	}
+	var out []DeviceEvent
+	present := map[uint32]bool{}
+	for i := range devs {
+		d := &devs[i]
+		if d.Type != ftdi.FT2232H {
+			continue
+		}
+		present[d.LocID] = true
+		if _, ok := known[d.LocID]; ok || d.Opened {
+			continue
+		}
+		s, c := chipSerial(d.Serial)
+		if d.Serial == "" {
+			// a blank EEPROM has no serial number but the driver still
+			// suffixes the description
+			if d.LocID == 0 || !strings.HasSuffix(d.Description, " A") {
+				continue
+			}
+		} else if c != 'A' {
+			continue
+		}
+		ev := DeviceEvent{Attached: true, Serial: s, Info: *d}
+		known[d.LocID] = ev
+		out = append(out, ev)
+	}
+	for loc, ev := range known {
+		if !present[loc] {
+			delete(known, loc)
+			ev.Attached = false
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// WaitForDevice waits until the FT2232H board with the chip serial number is
+// attached, or any board for an empty serial, and returns its channel A.
+func WaitForDevice(ctx context.Context, serial string) (DeviceInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for ev := range Watch(ctx, 200*time.Millisecond) {
+		if ev.Attached && (serial == "" || ev.Serial == serial) {
+			return ev.Info, nil
+		}
+	}
+	return DeviceInfo{}, ctx.Err()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ysh86/ft64/d2xx"
+)
+
+// drive prints the drive settings of a board, changes them, or reverts the
+// last change. The settings replaced are saved to drive-<serial>.json.
+//
+// Each setting is a pin group with its current and options, e.g.
+// "BH=8,slow,schmitt"; the groups not given are kept.
+func drive(args []string) {
+	if len(args) < 1 {
+		usage()
+		return
+	}
+	serial := args[0]
+	backup := "drive-" + serial + ".json"
+
+	// the EEPROM is shared by both channels
+	dev, err := d2xx.OpenBySerial(serial+"A", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	defer dev.Close()
+	s, err := dev.DriveSettings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	if len(args) == 1 {
+		fmt.Printf("%s: %s\n", serial, s)
+		return
+	}
+
+	revert := args[1] == "revert"
+	if revert {
+		b, err := os.ReadFile(backup)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return
+		}
+		if err := json.Unmarshal(b, &s); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %s\n", backup, err)
+			return
+		}
+	} else {
+		for _, arg := range args[1:] {
+			if err := parseDrive(&s, arg); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+				return
+			}
+		}
+	}
+
+	prev, err := dev.ProgramDriveSettings(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	if revert {
+		os.Remove(backup)
+	} else if _, err := os.Stat(backup); err == nil {
+		// keep the settings from before the first change
+		fmt.Printf("%s: keeping the settings saved in %s\n", serial, backup)
+	} else {
+		b, _ := json.MarshalIndent(prev, "", "  ")
+		if err := os.WriteFile(backup, append(b, '\n'), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		}
+	}
+	fmt.Printf("%s: was %s\n", serial, prev)
+	fmt.Printf("%s: now %s\n", serial, s)
+	fmt.Printf("%s: replug the board to use the new settings\n", serial)
+}
+
+// parseDrive parses a setting like "AL=8,slow,schmitt" into s.
+func parseDrive(s *d2xx.DriveSettings, arg string) error {
+	group, value, ok := strings.Cut(arg, "=")
+	if !ok {
+		return fmt.Errorf("invalid drive setting %q", arg)
+	}
+	var d *d2xx.Drive
+	switch strings.ToUpper(group) {
+	case "AL":
+		d = &s.AL
+	case "AH":
+		d = &s.AH
+	case "BL":
+		d = &s.BL
+	case "BH":
+		d = &s.BH
+	default:
+		return fmt.Errorf("invalid pin group %q, must be AL, AH, BL or BH", group)
+	}
+	opts := strings.Split(value, ",")
+	ma, err := strconv.ParseUint(strings.TrimSuffix(strings.ToLower(opts[0]), "ma"), 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid drive current %q", opts[0])
+	}
+	*d = d2xx.Drive{CurrentMA: uint8(ma)}
+	for _, o := range opts[1:] {
+		switch o {
+		case "slow":
+			d.SlowSlew = true
+		case "schmitt":
+			d.Schmitt = true
+		default:
+			return fmt.Errorf("invalid drive option %q, must be slow or schmitt", o)
+		}
+	}
+	return nil
+}
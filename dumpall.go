@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/ysh86/ft64/d2xx"
+	"github.com/ysh86/ft64/d2xx/ftdi"
+)
+
+// dumpAll dumps the carts of all the boards concurrently, each to
+// rom-<serial>.z64, or rom-<location>.z64 for a board without serial number.
+func dumpAll(args []string) {
+	if len(args) < 2 {
+		usage()
+		return
+	}
+	address, size := parseRange(args)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	opened, failed := 0, 0
+	failedOpen, stop := openBoards(true, func(b *openedBoard) {
+		opened++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer b.cancel()
+			defer b.rom.Close()
+			if err := dumpBoard(b.ctx, b.rom, b.name, address, size); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: error: %s\n", b.name, err)
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}()
+	})
+	defer stop()
+	wg.Wait()
+	if opened+failedOpen != 0 {
+		fmt.Printf("done: %d of %d boards dumped\n", opened-failed, opened+failedOpen)
+	}
+}
+
+// openedBoard is a board opened by openBoards.
+type openedBoard struct {
+	name string
+	rom  board
+	// ctx is canceled on interrupt or once the board is removed; the dump then
+	// stops and the board is closed by the caller.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// openBoards opens the boards attached, all of them or only the first one, and
+// calls opened with each board once open. Without any board, it fails if all
+// is set and waits for one to be attached otherwise.
+//
+// It returns how many boards couldn't be opened, after printing why, and a
+// function to call once the boards are closed.
+func openBoards(all bool, opened func(b *openedBoard)) (int, func()) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	// watch before opening, the serial number of an opened board is hidden
+	events := d2xx.Watch(ctx, 500*time.Millisecond)
+	infos, err := d2xx.FindROMs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return 0, stop
+	}
+	if len(infos) == 0 {
+		if all {
+			fmt.Fprintln(os.Stderr, "error: no board found")
+			return 0, stop
+		}
+		fmt.Println("waiting for a board...")
+		for ev := range events {
+			if ev.Attached {
+				infos = append(infos, ev.Info)
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			return 0, stop
+		}
+	}
+	if !all {
+		infos = infos[:1]
+	}
+
+	// a removal cancels the dump of the board, which is closed once done
+	var mu sync.Mutex
+	cancels := map[string]context.CancelFunc{}
+	go closeOnRemoval(events, func(name string) {
+		mu.Lock()
+		cancel := cancels[name]
+		mu.Unlock()
+		if cancel != nil {
+			fmt.Fprintf(os.Stderr, "\n%s: board removed\n", name)
+			cancel()
+		}
+	})
+	// open sequentially, the driver enumerates the devices each time
+	failed := 0
+	for i := range infos {
+		name, _ := boardName(&infos[i])
+		rom, err := openBoard(&infos[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", name, err)
+			failed++
+			continue
+		}
+		b := &openedBoard{name: name, rom: rom}
+		b.ctx, b.cancel = context.WithCancel(ctx)
+		mu.Lock()
+		cancels[name] = b.cancel
+		mu.Unlock()
+		warnChannels(name, rom)
+		rom.OnRecovery(func(channel string, s d2xx.RecoveryStep) {
+			fmt.Fprintf(os.Stderr, "%s: channel %s recovered by %s\n", name, channel, s)
+		})
+		opened(b)
+	}
+	return failed, stop
+}
+
+// boardName returns the name of a board and whether it has a serial number.
+func boardName(b *d2xx.DeviceInfo) (string, bool) {
+	if b.Serial != "" {
+		return b.Serial[:len(b.Serial)-1], true
+	}
+	return fmt.Sprintf("0x%x", b.LocID), false
+}
+
+// board is the cartridge dumper of one board.
+type board interface {
+	romReader
+	Read512(addr uint32) ([]byte, error)
+	DevInfo() (ftdi.DevType, uint16, uint16, error)
+	Close()
+	OnRecovery(f func(channel string, s d2xx.RecoveryStep))
+	ChannelIssues() []d2xx.ChannelIssue
+}
+
+// openBoard opens the dumper whose channel A is b.
+func openBoard(b *d2xx.DeviceInfo) (board, error) {
+	if name, ok := boardName(b); ok {
+		return d2xx.OpenROMBySerial(name, nil)
+	}
+	return d2xx.OpenROMByLocation(b.LocID, nil)
+}
+
+// dumpBoard dumps one board and prints its progress every 10%, until ctx is
+// done.
+func dumpBoard(ctx context.Context, rom romReader, name string, address, size uint32) error {
+	w, err := os.Create("rom-" + name + ".z64")
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	step := size / 10
+	next := step
+	done, err := dumpROM(ctx, rom, w, address, size, func(done uint32) {
+		if done >= next {
+			fmt.Printf("%s: %d%%\n", name, uint64(done)*100/uint64(size))
+			next += step
+		}
+	})
+	if err2 := w.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("%s: %d KB in %s: %.1f KB/s\n", name, done/1024, elapsed.Round(time.Millisecond), float64(done)/1024/elapsed.Seconds())
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/ysh86/ft64/d2xx"
+)
+
+// identity prints the identity of all the boards, or writes the identity of
+// one board during its assembly.
+func identity(args []string) {
+	if len(args) == 0 {
+		printIdentities()
+		return
+	}
+	if len(args) < 4 {
+		usage()
+		return
+	}
+	serial := args[0]
+	id := &d2xx.Identity{Model: args[1], Profile: args[3]}
+	v, err := strconv.ParseUint(args[2], 0, 8)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid revision: %v\n", args[2])
+		return
+	}
+	id.Revision = uint8(v)
+	if len(args) > 4 {
+		if v, err = strconv.ParseUint(args[4], 0, 32); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid clock: %v\n", args[4])
+			return
+		}
+		id.ClockHz = uint32(v)
+	}
+	if len(args) > 5 {
+		if v, err = strconv.ParseUint(args[5], 0, 16); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid address wait: %v\n", args[5])
+			return
+		}
+		id.AddressWait = uint16(v)
+	}
+	if _, err := d2xx.LookupProfile(id.Profile); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+
+	// the EEPROM is shared by both channels
+	dev, err := d2xx.OpenBySerial(serial+"A", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	defer dev.Close()
+	if err := dev.WriteIdentity(id); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	got, err := dev.Identity()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: verify: %s\n", err)
+		return
+	}
+	if *got != *id {
+		fmt.Fprintf(os.Stderr, "error: verify: read back %s\n", got)
+		return
+	}
+	fmt.Printf("%s: %s\n", serial, got)
+}
+
+// printIdentities prints the identity of each board.
+func printIdentities() {
+	boards, err := d2xx.FindROMs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	for _, b := range boards {
+		name, _ := boardName(&b)
+		dev, err := d2xx.Open(b.Index, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %s\n", name, err)
+			continue
+		}
+		id, err := dev.Identity()
+		dev.Close()
+		if err != nil {
+			fmt.Printf("%s: %s\n", name, err)
+			continue
+		}
+		fmt.Printf("%s: %s\n", name, id)
+	}
+}
@@ -2,42 +2,113 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/ysh86/ft64/d2xx"
 )
 
+// commands are the subcommands. Without one, the arguments are those of dump.
+var commands = map[string]func(args []string){
+	"list":      list,
+	"dumpall":   dumpAll,
+	"identity":  identity,
+	"channels":  channels,
+	"drive":     drive,
+	"provision": provision,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: cmd address sizeInKB")
+	fmt.Fprintln(os.Stderr, "       cmd list")
+	fmt.Fprintln(os.Stderr, "       cmd dumpall address sizeInKB")
+	fmt.Fprintln(os.Stderr, "       cmd identity [serial model revision profile [clockHz [addressWait]]]")
+	fmt.Fprintln(os.Stderr, "       cmd channels [fix]")
+	fmt.Fprintln(os.Stderr, "       cmd drive serial [group=mA[,slow][,schmitt]...|revert]")
+	fmt.Fprintln(os.Stderr, "       cmd provision -model model [flags]")
+	fmt.Fprintln(os.Stderr, "Any command can be preceded by -profile-file file.json to load the wiring")
+	fmt.Fprintln(os.Stderr, "profile of a board, by name in identity and provision; it may be repeated.")
+}
+
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: cmd address sizeInKB")
-		return
+	args := os.Args[1:]
+	for len(args) >= 2 && args[0] == "-profile-file" {
+		if err := loadProfile(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return
+		}
+		args = args[2:]
 	}
+	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			cmd(args[1:])
+			return
+		}
+	}
+	dump(args)
+}
 
-	i, err := strconv.ParseInt(os.Args[1], 0, 0)
+// loadProfile registers the wiring profile described by a JSON file, for the
+// boards whose identity names it.
+func loadProfile(path string) error {
+	f, err := os.Open(path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "invalid arg: %v\n", os.Args[1])
+		return err
 	}
-	address := uint32(i)
-	i, err = strconv.ParseInt(os.Args[2], 0, 0)
+	defer f.Close()
+	p, err := d2xx.LoadProfile(f)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "invalid arg: %v\n", os.Args[2])
+		return fmt.Errorf("%s: %w", path, err)
 	}
-	size := uint32(i) * 1024
+	return d2xx.RegisterProfile(p)
+}
+
+// list prints the connected devices.
+func list(args []string) {
+	devs, err := d2xx.ListDevices()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	for i := range devs {
+		fmt.Println(devs[i].String())
+	}
+}
+
+// dump waits for the user, then prints the header at address and dumps
+// sizeInKB from there to rom.z64.
+func dump(args []string) {
+	if len(args) < 2 {
+		usage()
+		return
+	}
+
+	address, size := parseRange(args)
 
 	verMajor, verMinor, verPatch := d2xx.Version()
 	fmt.Printf("d2xx library version: %d.%d.%d\n", verMajor, verMinor, verPatch)
 
-	rom, err := d2xx.OpenROM()
+	_, stop := openBoards(false, func(b *openedBoard) {
+		defer b.cancel()
+		defer b.rom.Close()
+		dumpInteractive(b.ctx, b.rom, address, size)
+	})
+	stop()
+	fmt.Println("done")
+}
+
+// dumpInteractive prints the header at address and dumps size bytes from
+// there to rom.z64 each time the user is ready, until ctx is done.
+func dumpInteractive(ctx context.Context, rom board, address, size uint32) {
+	devType, venID, devID, err := rom.DevInfo()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		return
 	}
-	//fmt.Printf("ROM handle: %v\n", rom)
-	defer rom.Close()
-
-	devType, venID, devID := rom.DevInfo()
 	fmt.Printf("DevType: %v(%d), vendor ID: 0x%04x, device ID: 0x%04x\n", devType, devType, venID, devID)
 
 	reader := bufio.NewReader(os.Stdin)
@@ -48,6 +119,9 @@ func main() {
 			fmt.Fprintf(os.Stderr, "error: %s\n", err)
 			break
 		}
+		if ctx.Err() != nil {
+			break
+		}
 
 		header, err := rom.Read512(address)
 		if err != nil {
@@ -69,15 +143,87 @@ func main() {
 			fmt.Fprintf(os.Stderr, "error: %s\n", err)
 			break
 		}
-		defer w.Close()
-		for addr := address; addr < address+size; addr += 512 {
-			data, err := rom.Read512(addr)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: %s\n", err)
-				break
+		start := time.Now()
+		done, err := dumpROM(ctx, rom, w, address, size, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		}
+		// the next dump truncates the file, close it now
+		if err := w.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		}
+		elapsed := time.Since(start)
+		fmt.Printf("%d KB in %s: %.1f KB/s\n", done/1024, elapsed.Round(time.Millisecond), float64(done)/1024/elapsed.Seconds())
+	}
+}
+
+// parseRange parses the address and sizeInKB arguments.
+func parseRange(args []string) (uint32, uint32) {
+	i, err := strconv.ParseInt(args[0], 0, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid arg: %v\n", args[0])
+	}
+	address := uint32(i)
+	i, err = strconv.ParseInt(args[1], 0, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid arg: %v\n", args[1])
+	}
+	return address, uint32(i) * 1024
+}
+
+// romReader is the part of the cartridge dumper used to dump.
+type romReader interface {
+	Read512Async(addr uint32) func() ([]byte, error)
+}
+
+// dumpROM writes size bytes from address to w and returns how many were
+// written. progress, if not nil, is called after each block. Once ctx is
+// done, it waits for the reads in flight and returns.
+func dumpROM(ctx context.Context, rom romReader, w io.Writer, address, size uint32, progress func(done uint32)) (uint32, error) {
+	// keep a few reads in flight to overlap the I/O with writing the file
+	const depth = 4
+	var pending []func() ([]byte, error)
+	var done uint32
+	for addr := address; addr < address+size || len(pending) != 0; {
+		if ctx.Err() != nil {
+			for _, p := range pending {
+				p()
+			}
+			return done, ctx.Err()
+		}
+		for ; addr < address+size && len(pending) < depth; addr += 512 {
+			pending = append(pending, rom.Read512Async(addr))
+		}
+		data, err := pending[0]()
+		pending = pending[1:]
+		if err != nil {
+			// wait for the reads in flight
+			for _, p := range pending {
+				p()
+			}
+			return done, err
+		}
+		if _, err := w.Write(data); err != nil {
+			for _, p := range pending {
+				p()
 			}
-			w.Write(data)
+			return done, err
+		}
+		done += uint32(len(data))
+		if progress != nil {
+			progress(done)
+		}
+	}
+	return done, nil
+}
+
+// closeOnRemoval calls removed with the name of each board detached, until
+// events is closed.
+func closeOnRemoval(events <-chan d2xx.DeviceEvent, removed func(name string)) {
+	for ev := range events {
+		if !ev.Attached {
+			name, _ := boardName(&ev.Info)
+			removed(name)
 		}
 	}
-	fmt.Println("done")
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ysh86/ft64/d2xx"
+)
+
+// provision programs the EEPROM of the new boards, those without a serial
+// number, and prints a line per board.
+//
+// The serial numbers follow the last one handed out, saved in
+// provision-<prefix>.txt, and the ones attached. A serial number already
+// attached is never handed out again.
+func provision(args []string) {
+	fs := flag.NewFlagSet("provision", flag.ExitOnError)
+	manufacturer := fs.String("manufacturer", "ft64", "USB manufacturer string")
+	desc := fs.String("desc", "N64 dumper", "USB product string")
+	prefix := fs.String("prefix", "FT64", "serial number prefix")
+	start := fs.Int("start", 0, "serial number of the first board, instead of the next one")
+	power := fs.Uint("power", 100, "max power drawn from the bus, in mA")
+	self := fs.Bool("self", false, "self powered")
+	model := fs.String("model", "", "board model, required")
+	revision := fs.Uint("revision", 1, "board revision")
+	profile := fs.String("profile", d2xx.DefaultProfile, "wiring profile")
+	all := fs.Bool("all", false, "also provision the boards already having a serial number")
+	fs.Parse(args)
+	if *model == "" || *power > 500 || *revision > 255 {
+		fs.Usage()
+		return
+	}
+	if _, err := d2xx.LookupProfile(*profile); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	boards, err := d2xx.FindROMs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	devs, err := d2xx.ListDevices()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	counter := "provision-" + *prefix + ".txt"
+	n, err := nextSerial(counter, *prefix, devs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return
+	}
+	if *start != 0 {
+		n = *start
+	}
+	done := 0
+	for _, b := range boards {
+		if _, ok := boardName(&b); ok && !*all {
+			continue
+		}
+		serial := fmt.Sprintf("%s%04d", *prefix, n)
+		if serialAttached(devs, serial) {
+			fmt.Fprintf(os.Stderr, "error: serial number %s is already attached\n", serial)
+			break
+		}
+		// reserve the serial number before it is programmed
+		if err := os.WriteFile(counter, []byte(strconv.Itoa(n+1)+"\n"), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			break
+		}
+		c := &d2xx.BoardConfig{
+			Manufacturer:   *manufacturer,
+			ManufacturerID: *prefix,
+			Description:    *desc,
+			Serial:         serial,
+			MaxPowerMA:     uint16(*power),
+			SelfPowered:    *self,
+			Identity:       &d2xx.Identity{Model: *model, Revision: uint8(*revision), Profile: *profile},
+		}
+		n++
+		info, err := provisionBoard(ctx, &b, c)
+		if err != nil {
+			fmt.Printf("%s: location 0x%x: error: %s\n", c.Serial, b.LocID, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		done++
+		fmt.Printf("%s: location 0x%x: ok: %q %q, %dmA, %s\n", c.Serial, info.LocID, c.Manufacturer, info.Description, c.MaxPowerMA, c.Identity)
+	}
+	fmt.Printf("done: %d boards provisioned\n", done)
+}
+
+// provisionTimeout is how long a board has to enumerate again, replugged by
+// hand if need be.
+const provisionTimeout = 30 * time.Second
+
+// nextSerial returns the number following both the one saved in counter and
+// the serial numbers attached with the prefix.
+func nextSerial(counter, prefix string, devs []d2xx.DeviceInfo) (int, error) {
+	n := 1
+	if b, err := os.ReadFile(counter); err == nil {
+		if n, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+			return 0, fmt.Errorf("%s: %w", counter, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	for i := range devs {
+		s := strings.TrimSuffix(strings.TrimSuffix(devs[i].Serial, "A"), "B")
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+		if v, err := strconv.Atoi(s[len(prefix):]); err == nil && v >= n {
+			n = v + 1
+		}
+	}
+	return n, nil
+}
+
+// serialAttached returns true if a channel of a chip with the serial number
+// is attached.
+func serialAttached(devs []d2xx.DeviceInfo, serial string) bool {
+	for i := range devs {
+		if devs[i].Serial == serial+"A" || devs[i].Serial == serial+"B" {
+			return true
+		}
+	}
+	return false
+}
+
+// provisionBoard programs the board whose channel A is b, waits for it to
+// enumerate with the new serial number and verifies it.
+//
+// The board is opened by location: the boards programmed before re-enumerate,
+// which makes the device indexes stale.
+func provisionBoard(ctx context.Context, b *d2xx.DeviceInfo, c *d2xx.BoardConfig) (d2xx.DeviceInfo, error) {
+	dev, err := d2xx.OpenByLocation(b.LocID, nil)
+	if err != nil {
+		return d2xx.DeviceInfo{}, err
+	}
+	if err := dev.ProgramBoard(c); err != nil {
+		dev.Close()
+		return d2xx.DeviceInfo{}, err
+	}
+	if err := dev.Reenumerate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: replug the board\n", c.Serial)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, provisionTimeout)
+	defer cancel()
+	info, err := d2xx.WaitForDevice(ctx, c.Serial)
+	if err != nil {
+		return info, fmt.Errorf("board didn't enumerate again: %w", err)
+	}
+	if info.Description != c.Description+" A" {
+		return info, fmt.Errorf("board enumerated as %q", info.Description)
+	}
+	dev, err = d2xx.OpenBySerial(info.Serial, nil)
+	if err != nil {
+		return info, err
+	}
+	defer dev.Close()
+	return info, dev.VerifyBoard(c)
+}